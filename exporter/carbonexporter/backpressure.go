@@ -0,0 +1,32 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// backpressureExporter counts the data points refused by the sending queue
+// of the wrapped exporter. With the queue enabled, ConsumeMetrics only fails
+// when the queue is full: the exports themselves happen in the background.
+type backpressureExporter struct {
+	exporter.Metrics
+	telemetry *exporterTelemetry
+	drops     *atomic.Int64
+}
+
+func (e *backpressureExporter) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	// The queue may take ownership of md, count its points beforehand.
+	points := md.DataPointCount()
+	err := e.Metrics.ConsumeMetrics(ctx, md)
+	if err != nil {
+		e.drops.Add(int64(points))
+		e.telemetry.recordDroppedPoints(ctx, map[string]int{dropReasonBackpressure: points})
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"bytes"
+	"sync"
+)
+
+// BufferPool provides the buffers the data is serialized into before being
+// sent. It can be replaced via WithBufferPool, e.g. to share buffers with
+// other components.
+type BufferPool interface {
+	// Get returns a buffer, it is reset before being used.
+	Get() *bytes.Buffer
+	// Put returns a buffer obtained from Get once it is no longer used.
+	Put(buf *bytes.Buffer)
+}
+
+// syncBufferPool is the BufferPool backed by a sync.Pool.
+type syncBufferPool struct {
+	pool sync.Pool
+}
+
+func newSyncBufferPool() *syncBufferPool {
+	return &syncBufferPool{
+		pool: sync.Pool{
+			New: func() any {
+				return new(bytes.Buffer)
+			},
+		},
+	}
+}
+
+func (p *syncBufferPool) Get() *bytes.Buffer {
+	return p.pool.Get().(*bytes.Buffer)
+}
+
+func (p *syncBufferPool) Put(buf *bytes.Buffer) {
+	p.pool.Put(buf)
+}
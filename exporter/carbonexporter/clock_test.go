@@ -0,0 +1,134 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestMaxPointAgeWithFakeClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC))
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	cfg := createDefaultConfig().(*Config)
+	cfg.MaxPointAge = time.Minute
+	f, err := newFormatter(cfg, telemetry, clock)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(clock.Now()))
+	dp.SetIntValue(1)
+
+	assert.Equal(t, "gauge 1 1701424800\n", f.metricDataToPlaintext(context.Background(), md))
+
+	// The same point becomes too old once the clock moves past the max age.
+	clock.Advance(time.Minute + time.Second)
+	assert.Empty(t, f.metricDataToPlaintext(context.Background(), md))
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := newFakeClock(time.Unix(0, 0))
+	ticker := clock.NewTicker(10 * time.Second)
+
+	clock.Advance(5 * time.Second)
+	assert.Empty(t, ticker.C())
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, time.Unix(10, 0), <-ticker.C())
+
+	ticker.Stop()
+	clock.Advance(10 * time.Second)
+	assert.Empty(t, ticker.C())
+}
+
+// fakeClock is a Clock that only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ft := &fakeTicker{
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, ft)
+	return ft
+}
+
+// tickerCount returns the number of tickers created so far.
+func (c *fakeClock) tickerCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+// Advance moves the clock forward delivering a tick to each ticker whose
+// period elapsed. As with time.Ticker, ticks are dropped for slow receivers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, ft := range c.tickers {
+		ft.tick(c.now)
+	}
+}
+
+type fakeTicker struct {
+	mu      sync.Mutex
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (ft *fakeTicker) C() <-chan time.Time {
+	return ft.c
+}
+
+func (ft *fakeTicker) Stop() {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.stopped = true
+}
+
+func (ft *fakeTicker) tick(now time.Time) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.stopped || now.Before(ft.next) {
+		return
+	}
+	for !now.Before(ft.next) {
+		ft.next = ft.next.Add(ft.period)
+	}
+	select {
+	case ft.c <- now:
+	default:
+	}
+}
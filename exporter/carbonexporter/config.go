@@ -7,8 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"go.opentelemetry.io/collector/config/confignet"
+	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/config/configtls"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/resourcetotelemetry"
@@ -16,7 +20,64 @@ import (
 
 // Defaults for not specified configuration settings.
 const (
-	defaultEndpoint = "localhost:2003"
+	defaultEndpoint                   = "localhost:2003"
+	defaultCrossExportDedupMaxEntries = 100000
+	defaultSeriesCountMaxSeries       = 100000
+)
+
+// Supported values for Config.TagKeyCollisionPolicy.
+const (
+	tagKeyCollisionKeepFirst    = "keep-first"
+	tagKeyCollisionSuffix       = "suffix"
+	tagKeyCollisionConcatValues = "concat-values"
+)
+
+// Supported values for Config.Transport.
+const (
+	transportTCP = "tcp"
+	transportUDP = "udp"
+	// transportUnix connects to the Unix domain socket at Config.Endpoint.
+	transportUnix = "unix"
+)
+
+// Supported values for Config.Encoding.
+const (
+	encodingPlaintext = "plaintext"
+	encodingPickle    = "pickle"
+)
+
+// Supported values for Config.MetricsFormat.
+const (
+	metricsFormatTags   = "tags"
+	metricsFormatDotted = "dotted"
+)
+
+// Supported values for Config.NaNHandling.
+const (
+	nanHandlingSkip        = "skip"
+	nanHandlingZero        = "zero"
+	nanHandlingPassthrough = "passthrough"
+)
+
+// Supported values for Config.DefaultTemporality.
+const (
+	temporalityCumulative = "cumulative"
+	temporalityDelta      = "delta"
+)
+
+// Supported values for Config.ConcurrentDuplicatePolicy.
+const (
+	duplicatePolicyDrop = "drop"
+	duplicatePolicyLast = "last"
+	duplicatePolicyMax  = "max"
+	duplicatePolicySum  = "sum"
+)
+
+// Supported values for Config.UnicodePolicy.
+const (
+	unicodePolicyKeep               = "keep"
+	unicodePolicyASCIITransliterate = "ascii-transliterate"
+	unicodePolicyStrip              = "strip"
 )
 
 // Config defines configuration for Carbon exporter.
@@ -24,21 +85,685 @@ type Config struct {
 	// Specifies the connection endpoint config. The default value is "localhost:2003".
 	confignet.TCPAddr `mapstructure:",squash"` // squash ensures fields are correctly decoded in embedded struct.
 
+	// TLSSetting secures the TCP connections with TLS. The default value is
+	// nil, which keeps the connections in plaintext.
+	TLSSetting *configtls.TLSClientSetting `mapstructure:"tls"`
+
 	// Timeout is the maximum duration allowed to connecting and sending the
 	// data to the Carbon/Graphite backend. The default value is 5s.
 	exporterhelper.TimeoutSettings `mapstructure:",squash"`     // squash ensures fields are correctly decoded in embedded struct.
 	QueueConfig                    exporterhelper.QueueSettings `mapstructure:"sending_queue"`
 	RetryConfig                    exporterhelper.RetrySettings `mapstructure:"retry_on_failure"`
 
+	// WriteTimeout is the maximum duration allowed to write each batch once
+	// connected, leaving Timeout to bound connecting. The default value is 0,
+	// which bounds both with Timeout.
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+
 	// ResourceToTelemetrySettings defines configuration for converting resource attributes to metric labels.
 	ResourceToTelemetryConfig resourcetotelemetry.Settings `mapstructure:"resource_to_telemetry_conversion"`
+
+	// TagKeyCollisionPolicy defines what happens when two attribute keys are
+	// identical after being sanitized into tag keys. Valid values are
+	// "keep-first" (only the first attribute is kept), "suffix" (later keys
+	// get a "_<n>" suffix) and "concat-values" (values are joined with ",").
+	// The default value is "keep-first".
+	TagKeyCollisionPolicy string `mapstructure:"tag_key_collision_policy"`
+
+	// MaxPointAge drops data points whose timestamp is older than the given age
+	// at the moment they are exported, so late data doesn't overwrite newer
+	// points in Graphite. The default value is 0, which keeps all points.
+	MaxPointAge time.Duration `mapstructure:"max_point_age"`
+
+	// FieldOrder is a template to change the order of the fields of each line
+	// for Graphite compatible backends that don't use the standard order, e.g.
+	// "{timestamp} {name} {value}". It must reference each of the {name},
+	// {value} and {timestamp} placeholders exactly once, {name} being the full
+	// metric path including tags. The default value is empty, which uses the
+	// standard "<path> <value> <timestamp>" order.
+	FieldOrder string `mapstructure:"field_order"`
+
+	// AggregationMethodTag adds an "aggregationMethod" tag to every line hinting
+	// Graphite on how to roll up the series: "average" for gauges, "sum" for
+	// delta sums and histograms, "max" for cumulative monotonic sums, histograms
+	// and summaries, and "last" for cumulative non-monotonic sums.
+	AggregationMethodTag bool `mapstructure:"aggregation_method_tag"`
+
+	// EscapeSeparatorInName replaces the Separator with "_" when it appears
+	// between two digits in a metric name, e.g. "app.v1.2.latency" becomes
+	// "app.v1_2.latency", so versions and similar tokens are kept as a single
+	// path component. The default value is false.
+	EscapeSeparatorInName bool `mapstructure:"escape_separator_in_name"`
+
+	// MinNonZeroValue rounds the non-zero floating point values closer to zero
+	// than it to either 0 or the minimum, whichever is nearest, e.g. with 1e-6
+	// the value 1e-9 is sent as 0 and 7e-7 as 0.000001. The default value is 0,
+	// which keeps all values as they are.
+	MinNonZeroValue float64 `mapstructure:"min_non_zero_value"`
+
+	// PipelineTag adds a "pipeline" tag with the given value to every line so
+	// the output of multiple Carbon exporters can be told apart in Graphite,
+	// e.g. the name of the exporter. The default value is empty, which adds no
+	// tag.
+	PipelineTag string `mapstructure:"pipeline_tag"`
+
+	// PrecisionByUnit sets the number of decimals of the floating point values
+	// of metrics per unit, e.g. {"ms": 3, "1": 0}. Metrics whose unit is not
+	// listed keep the shortest representation of their values. The default
+	// value is empty.
+	PrecisionByUnit map[string]int `mapstructure:"precision_by_unit"`
+
+	// SuppressZeros lists regular expressions matched against the whole metric
+	// name, the zero valued data points of gauges and sums of matching metrics
+	// are dropped to save retention on mostly zero series. The default value is
+	// empty.
+	SuppressZeros []string `mapstructure:"suppress_zeros"`
+
+	// EmitDropSamples emits, at most once a minute per drop reason, the line of
+	// one of the dropped data points prefixed with "dropped." and tagged with a
+	// "drop_reason" tag, so operators can see what is being dropped. The
+	// default value is false.
+	EmitDropSamples bool `mapstructure:"emit_drop_samples"`
+
+	// DeterministicOrder sorts the tags of each line by key and the lines of
+	// each export, so the same data always produces byte-identical output
+	// regardless of the order of resources, scopes, metrics, data points and
+	// attributes. The default value is false.
+	DeterministicOrder bool `mapstructure:"deterministic_order"`
+
+	// MaxLinesPerExport caps the number of lines sent per export, the excess
+	// lines are dropped and reported as dropped points. Combined with
+	// DeterministicOrder the lines kept are the first ones once sorted. The
+	// default value is 0, which disables the cap.
+	MaxLinesPerExport int `mapstructure:"max_lines_per_export"`
+
+	// BatchSentinel is the metric path of a line appended after the data of
+	// each export, its value being the number of data points converted into
+	// the lines sent before it, so consumers can detect where batches end.
+	// The default value is empty, which disables the sentinel.
+	BatchSentinel string `mapstructure:"batch_sentinel"`
+
+	// ResourceAttributesAsJSONTag encodes all the resource attributes as JSON
+	// into a single URL-escaped "resource" tag, trading queryability for a
+	// lower number of tags. It cannot be combined with
+	// resource_to_telemetry_conversion. The default value is false.
+	ResourceAttributesAsJSONTag bool `mapstructure:"resource_attributes_as_json_tag"`
+
+	// ResourceAttributesAsTags lists the resource attributes added, when
+	// present, as tags keyed by the attribute name, e.g. to keep
+	// "service.name" while leaving out high-cardinality ones like "host.id".
+	// It cannot be combined with resource_to_telemetry_conversion, which
+	// promotes all of them. The default value is empty, which adds none.
+	ResourceAttributesAsTags []string `mapstructure:"resource_attributes_as_tags"`
+
+	// HashTagValues lists the attributes, e.g. "user.id", whose values are
+	// replaced by their HMAC-SHA256 keyed with HashTagValuesSalt, hex-encoded,
+	// so the series stay distinct without writing the values. They are hashed
+	// wherever they are written, be they attributes of the data points, the
+	// scopes or the resources: in the tags, the resource tags, the environment
+	// segment and the PathTemplate data. The default value is empty, which
+	// hashes none.
+	HashTagValues []string `mapstructure:"hash_tag_values"`
+
+	// HashTagValuesSalt is the secret key of the hashes of HashTagValues,
+	// required when it is set so the values can't be recovered by hashing
+	// the likely ones.
+	HashTagValuesSalt configopaque.String `mapstructure:"hash_tag_values_salt"`
+
+	// KubernetesTags adds the "k8s.namespace.name", "k8s.pod.name" and
+	// "k8s.deployment.name" resource attributes, when present, as the short
+	// "ns", "pod" and "deploy" tags. The default value is false.
+	KubernetesTags bool `mapstructure:"kubernetes_tags"`
+
+	// DualStack dials the IPv4 and IPv6 addresses of endpoints having both
+	// concurrently ("Happy Eyeballs", RFC 6555) using the first connection
+	// established, so an unreachable address family doesn't block the
+	// exports. When disabled the addresses are dialed one after the other. The
+	// default value is true.
+	DualStack bool `mapstructure:"dual_stack"`
+
+	// UnhealthyThreshold is the number of consecutive failed writes after
+	// which the exporter reports a recoverable error status, the status is
+	// reported as OK again on the next successful write. The default value is
+	// 0, which doesn't report the status.
+	UnhealthyThreshold int `mapstructure:"unhealthy_threshold"`
+
+	// ReadinessProbeLine is sent on each new connection before any data, the
+	// connection is only used once the server replies to it with a non-empty
+	// line within the timeout. Meant for relays that answer to a probe. The
+	// default value is empty, which disables the probe.
+	ReadinessProbeLine string `mapstructure:"readiness_probe_line"`
+
+	// TagSeparator is written before each tag of a line. The default value is
+	// ";", per the Graphite tags format.
+	TagSeparator string `mapstructure:"tag_separator"`
+
+	// TagKVSeparator is written between the key and the value of each tag,
+	// e.g. ":" for backends using "key:value" tags. The default value is "=".
+	TagKVSeparator string `mapstructure:"tag_kv_separator"`
+
+	// EmitScopeMeta adds, once per instrumentation scope per export, a
+	// "otel.scope;name=<scope>;version=<version> 1 <now>" line recording the
+	// provenance of the data. The default value is false.
+	EmitScopeMeta bool `mapstructure:"emit_scope_meta"`
+
+	// IncludeScopeVersionTag adds a "scope_version=<version>" tag to the lines
+	// of the metrics whose instrumentation scope has a version, to track the
+	// version of the libraries producing them. The default value is false.
+	IncludeScopeVersionTag bool `mapstructure:"include_scope_version_tag"`
+
+	// IncludeScope inserts the name of the instrumentation scope of the
+	// metrics, with its dots and whitespaces replaced by "_", as a path
+	// segment before the metric name, so the metrics of the same name from
+	// different scopes don't collide. It is omitted for scopes without a name.
+	// The default value is false.
+	IncludeScope bool `mapstructure:"include_scope"`
+
+	// IncludeScopeAttributes adds the attributes of the instrumentation scope
+	// of the metrics as tags. A data point attribute of the same key takes
+	// precedence, whatever TagKeyCollisionPolicy. The default value is false.
+	IncludeScopeAttributes bool `mapstructure:"include_scope_attributes"`
+
+	// ShutdownDrainPolicy defines what happens on shutdown to the data still
+	// in the sending queue. Valid values are "flush" (the data is sent) and
+	// "drop" (the data is discarded). The default value is "flush".
+	ShutdownDrainPolicy string `mapstructure:"shutdown_drain_policy"`
+
+	// ShutdownDrainTimeout bounds the time spent flushing the sending queue
+	// on shutdown, the data still queued once it expires is discarded. The
+	// default value is 0, which flushes the whole queue.
+	ShutdownDrainTimeout time.Duration `mapstructure:"shutdown_drain_timeout"`
+
+	// ShutdownGracePeriod bounds the time the shutdown waits, once the queue
+	// is drained, for the exports still being sent before closing the
+	// connections, which interrupts them. The default value is 0, which
+	// waits for all of them.
+	ShutdownGracePeriod time.Duration `mapstructure:"shutdown_grace_period"`
+
+	// MonotonicSuffix is appended to the name of monotonic sums, e.g. ".total"
+	// to follow the Prometheus convention for counters. The default value is
+	// empty, which keeps the names as they are.
+	MonotonicSuffix string `mapstructure:"monotonic_suffix"`
+
+	// NoDelay disables Nagle's algorithm on the connections (TCP_NODELAY) so
+	// small writes are sent right away, favoring latency. When disabled small
+	// writes are coalesced, favoring throughput. The default value is true.
+	NoDelay bool `mapstructure:"no_delay"`
+
+	// KeepAlivePeriod enables TCP keep-alives on the connections, probing
+	// them after they were idle for KeepAlivePeriod, so that the connections
+	// dropped silently, e.g. by an idle relay, are detected before the next
+	// write. A negative value disables the keep-alives. The default value is
+	// 0, which keeps the Go default of 15s.
+	KeepAlivePeriod time.Duration `mapstructure:"keep_alive_period"`
+
+	// Linger sets how the data not yet sent is handled when the TCP
+	// connections are closed (SO_LINGER). 0 discards the data and resets the
+	// connection. A positive value, in whole seconds, makes Close wait, up to
+	// Linger, for the data to be sent. The default value is unset, which keeps
+	// the system default: Close returns immediately and the data is sent in
+	// the background.
+	Linger *time.Duration `mapstructure:"linger"`
+
+	// MaxConnections is the maximum number of TCP connections to Carbon,
+	// concurrent exports each use their own connection up to this limit and
+	// then wait for one to be available. Connections are created as needed.
+	// The default value is 0, which doesn't limit the number of connections.
+	MaxConnections int `mapstructure:"max_connections"`
+
+	// StartRetry configures retrying to start the exporter, e.g. while the
+	// storage extension backing a persistent sending queue is briefly
+	// unavailable, instead of failing right away.
+	StartRetry StartRetryConfig `mapstructure:"start_retry"`
+
+	// StartupJitter delays the first write after the exporter starts by a
+	// random duration between 0 and StartupJitter, so a fleet of collectors
+	// started together doesn't connect to Carbon all at once. The default
+	// value is 0, which doesn't delay it.
+	StartupJitter time.Duration `mapstructure:"startup_jitter"`
+
+	// GeoTags are tags locating the collector, e.g. {"region": "us-east-1",
+	// "zone": "a"}, added to every line. The values can be sourced from the
+	// environment with the "${env:VAR}" syntax. The default value is empty.
+	GeoTags map[string]string `mapstructure:"geo_tags"`
+
+	// MetricTags are tags added to the lines of specific metrics, keyed by
+	// metric name, e.g. {"http.server.duration": {"team": "web"}}. The
+	// default value is empty.
+	MetricTags map[string]map[string]string `mapstructure:"metric_tags"`
+
+	// RetentionClasses adds a "retention" tag to the metrics whose name
+	// matches the pattern of a class, the first matching class is used. The
+	// lines of each class are sent grouped together after the lines of the
+	// other metrics, so backends with tag based retention write them together,
+	// unless DeterministicOrder sorts them. The default value is empty.
+	RetentionClasses []RetentionClass `mapstructure:"retention_classes"`
+
+	// MaintenanceWindows are periods during which the exports are dropped,
+	// e.g. while Graphite is known to be unhealthy. The dropped data points are
+	// reported with the "maintenance" reason. The default value is empty.
+	MaintenanceWindows []MaintenanceWindow `mapstructure:"maintenance_windows"`
+
+	// UnicodePolicy defines how non-ASCII characters in metric names and tag
+	// values are handled. Valid values are "keep" (sent as they are),
+	// "ascii-transliterate" (accented letters lose their accents, e.g. "é"
+	// becomes "e", other characters are removed) and "strip" (removed). The
+	// default value is "keep".
+	UnicodePolicy string `mapstructure:"unicode_policy"`
+
+	// EmitShutdownMarker sends a "collector.shutdown 1 <now>" line on shutdown,
+	// once the sending queue is drained, marking that the exporter stopped
+	// cleanly. The default value is false.
+	EmitShutdownMarker bool `mapstructure:"emit_shutdown_marker"`
+
+	// StrictShutdown makes the shutdown fail when the shutdown marker can't
+	// be sent, e.g. because Carbon closed the connection while the sending
+	// queue was drained, or when the ShutdownGracePeriod expires. Otherwise
+	// the failure is logged and the shutdown succeeds. The default value is
+	// false.
+	StrictShutdown bool `mapstructure:"strict_shutdown"`
+
+	// Transport is the protocol used to send the data, "tcp", "udp" or "unix".
+	// With "udp" each line is sent as a datagram, or coalesced per MTU, and the
+	// socket is never re-established on write failures. The timeout applies as
+	// the write deadline. With "unix" the endpoint is the path of a Unix
+	// domain socket, used as the TCP connections are. The default value is
+	// empty, which uses "tcp".
+	Transport string `mapstructure:"transport"`
+
+	// MTU is the maximum size of the UDP datagrams, as many whole lines as fit
+	// are coalesced into each datagram. The default value is 0, which sends
+	// each line in its own datagram.
+	MTU int `mapstructure:"mtu"`
+
+	// ResourcelessPrefix is prepended, followed by a ".", to the path of the
+	// metrics whose resource has no attributes, keeping them in a dedicated
+	// subtree, e.g. "unknown_service". The default value is empty, which
+	// keeps the paths as they are.
+	ResourcelessPrefix string `mapstructure:"resourceless_prefix"`
+
+	// MergeHistogramFragments merges the histogram data points of the same
+	// series, ie.: with the same attributes and timestamps, split across
+	// several data points of a metric into a single data point before
+	// serializing it, adding up their counts, sums and bucket counts.
+	// Fragments with different explicit bounds are not merged. The default
+	// value is false.
+	MergeHistogramFragments bool `mapstructure:"merge_histogram_fragments"`
+
+	// Encoding is the wire format of the data, "plaintext" or "pickle".
+	// With "pickle" the data points of each export are sent in a single
+	// frame of the Carbon pickle protocol, see PickleMaxFrameBytes, which
+	// requires the "tcp" transport and doesn't support FieldOrder nor
+	// ReadinessProbeLine. The default value is empty, which uses
+	// "plaintext".
+	Encoding string `mapstructure:"encoding"`
+
+	// PickleMaxFrameBytes splits the data points of each export sent with the
+	// pickle encoding across as many frames as needed for each frame to be
+	// at most PickleMaxFrameBytes long, length included. The default value is
+	// 0, which sends a single frame.
+	PickleMaxFrameBytes int `mapstructure:"pickle_max_frame_bytes"`
+
+	// Prefix is prepended, followed by a ".", to the path of every metric,
+	// e.g. "prod.collector" turns "test_0" into "prod.collector.test_0",
+	// and of every line generated by the exporter, e.g. the shutdown marker
+	// or the batch sentinel. A trailing "." is ignored. The default value
+	// is empty, which keeps the paths as they are.
+	Prefix string `mapstructure:"prefix"`
+
+	// Separator joins the segments of the paths built by the exporter: the
+	// prefix, the environment and scope segments, the suffixes such as
+	// ".count" and the built-in paths. The dots inside metric names are kept.
+	// The default value is ".".
+	Separator string `mapstructure:"separator"`
+
+	// CrossExportDedupWindow drops the lines, identified by their path and
+	// timestamp, already written by a previous export within this window,
+	// e.g. when the same data is exported again by different pipelines. The
+	// default value is 0, which doesn't drop them.
+	CrossExportDedupWindow time.Duration `mapstructure:"cross_export_dedup_window"`
+
+	// CrossExportDedupMaxEntries is the maximum number of lines remembered
+	// for CrossExportDedupWindow, the oldest ones are forgotten first. The
+	// default value is 100000.
+	CrossExportDedupMaxEntries int `mapstructure:"cross_export_dedup_max_entries"`
+
+	// ConcurrentDuplicatePolicy decides which value wins when lines with the
+	// same path and timestamp are written within CrossExportDedupWindow, e.g.
+	// by concurrent producers. "drop" drops the later lines, "last" writes
+	// them, "max" only writes those whose value isn't lower than the one
+	// already written and "sum" writes the sum of the values written so far,
+	// Graphite keeping the last line written for each timestamp. The decisions
+	// of concurrent exports are serialized, the values of the failed ones are
+	// forgotten. It requires a positive CrossExportDedupWindow unless "drop".
+	// The default value is "drop".
+	ConcurrentDuplicatePolicy string `mapstructure:"concurrent_duplicate_policy"`
+
+	// EmitMovingAverage adds, for each gauge data point, a "<name>.avg" line
+	// with the moving average of its series over the last points, smoothing
+	// noisy gauges.
+	EmitMovingAverage MovingAverageConfig `mapstructure:"emit_moving_average"`
+
+	// MetricsFormat defines how the attributes are written in the path,
+	// "tags" appends them as Graphite tags, e.g. "test_0;k0=v0;k1=v1", while
+	// "dotted" folds them, sorted by key, into the dotted path for stores
+	// without tag support, e.g. "test_0.k0.v0.k1.v1". With "dotted" the dots
+	// and whitespaces in keys and values are replaced by "_", and
+	// TagSeparator and TagKVSeparator are not used. The default value is
+	// "tags".
+	MetricsFormat string `mapstructure:"metrics_format"`
+
+	// MetricTypes lists the types of the metrics converted, among "gauge",
+	// "sum", "histogram" and "summary", the metrics of the other types are
+	// skipped without error. Exponential histograms are never converted. The
+	// default value is empty, which converts all of them.
+	MetricTypes []string `mapstructure:"metric_types"`
+
+	// IntervalAttribute names the data point attribute holding the collection
+	// interval of the point, in seconds, e.g. "scrape_interval". The timestamp
+	// of the points with a positive interval is rounded down to a multiple of
+	// it, the other points keep theirs. The default value is empty, which
+	// keeps all the timestamps as they are.
+	IntervalAttribute string `mapstructure:"interval_attribute"`
+
+	// PathTemplate is a Go text/template rendering, for each data point, the
+	// name written in place of the metric name, e.g.
+	// `{{index .ResourceAttributes "deployment.environment"}}.{{.MetricName}}`.
+	// It can use .MetricName, the name as written without a template,
+	// .ResourceAttributes, .Attributes, the attributes of the data point, and
+	// .Scope with its .Name and .Version. Prefix and the other path segments
+	// are still prepended and the tags appended. The result is sanitized like
+	// the metric names, per SanitizeNames and UnicodePolicy, its whitespaces
+	// being replaced by "_" in any case. The metric name is used when the
+	// template renders nothing, or fails, which is counted. A template that
+	// fails on a sample data point is rejected by Validate. The default value
+	// is empty, which writes the metric name.
+	PathTemplate string `mapstructure:"path_template"`
+
+	// EmitUptime periodically sends a "<prefix>.collector.uptime_seconds" line
+	// with the time elapsed since the exporter started, e.g. to correlate
+	// restarts with gaps in the data. The "<prefix>." part is omitted when
+	// Prefix is empty.
+	EmitUptime UptimeConfig `mapstructure:"emit_uptime"`
+
+	// EmitBackpressureDrops periodically sends a
+	// "<prefix>.collector.backpressure_drops" line with the number of data
+	// points refused since the start because the sending queue was full. The
+	// "<prefix>." part is omitted when Prefix is empty. It requires the
+	// sending queue.
+	EmitBackpressureDrops BackpressureDropsConfig `mapstructure:"emit_backpressure_drops"`
+
+	// SeriesCountInterval is the period of the
+	// "<prefix>.collector.active_series" line counting the distinct series,
+	// i.e. paths including the tags, sent since the previous one, to track
+	// the cardinality reaching Carbon. The "<prefix>." part is omitted when
+	// Prefix is empty. The default value is 0, which doesn't send it.
+	SeriesCountInterval time.Duration `mapstructure:"series_count_interval"`
+
+	// SeriesCountMaxSeries bounds the number of distinct series tracked for
+	// SeriesCountInterval, the count doesn't go beyond. The default value is
+	// 100000.
+	SeriesCountMaxSeries int `mapstructure:"series_count_max_series"`
+
+	// SanitizeNames replaces the whitespaces, which separate the fields and
+	// the lines, and the ";" and "=" characters, which delimit the tags, by
+	// SanitizeReplacement in metric names, tag keys and tag values, as well
+	// as the TagSeparator and TagKVSeparator when they are not the default
+	// ones. The default value is true.
+	SanitizeNames bool `mapstructure:"sanitize_names"`
+
+	// SanitizeReplacement replaces each character sanitized per
+	// SanitizeNames, an empty replacement removes them. The default value is
+	// "_".
+	SanitizeReplacement string `mapstructure:"sanitize_replacement"`
+
+	// RepeatLastValue periodically re-sends the last value of each gauge
+	// series while no new value arrives, so rarely updated gauges don't show
+	// gaps in Graphite.
+	RepeatLastValue RepeatLastValueConfig `mapstructure:"repeat_last_value"`
+
+	// SumConversion converts the cumulative monotonic sums into the increase,
+	// or the per-second rate, since the previous point of their series, so
+	// Graphite doesn't need nonNegativeDerivative to chart them.
+	SumConversion SumConversionConfig `mapstructure:"sum_conversion"`
+
+	// NaNHandling defines how the NaN and infinite values of gauges and sums,
+	// which Graphite can't store, are handled. Valid values are "skip" (the
+	// data points are dropped and reported with the "non_finite" reason),
+	// "zero" (sent as 0) and "passthrough" (sent as "NaN", "+Inf" or "-Inf").
+	// The default value is "skip".
+	NaNHandling string `mapstructure:"nan_handling"`
+
+	// DefaultTemporality is the aggregation temporality assumed for the sums
+	// and histograms whose temporality is unspecified, it drives how they are
+	// converted, e.g. the "aggregationMethod" tag. Valid values are
+	// "cumulative" and "delta". The default value is "cumulative".
+	DefaultTemporality string `mapstructure:"default_temporality"`
+
+	// EnvironmentInPath inserts the "deployment.environment" resource
+	// attribute as the leading segment of the paths, after Prefix, e.g.
+	// "prod.<metric>".
+	EnvironmentInPath EnvironmentInPathConfig `mapstructure:"environment_in_path"`
+
+	// Reconnect re-dials, with an exponential backoff, the TCP or Unix socket
+	// connections broken while writing a batch and writes the batch again on
+	// the new connection, instead of failing the export.
+	Reconnect ReconnectConfig `mapstructure:"reconnect"`
+}
+
+// MaintenanceWindow is the period between Start, included, and End, excluded.
+type MaintenanceWindow struct {
+	// Start is the beginning of the window, in RFC 3339 format.
+	Start time.Time `mapstructure:"start"`
+
+	// End is the end of the window, in RFC 3339 format.
+	End time.Time `mapstructure:"end"`
+}
+
+func (w MaintenanceWindow) contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// RetentionClass maps the metrics whose name matches Pattern to a retention.
+type RetentionClass struct {
+	// Pattern is a regular expression matched against the whole metric name.
+	Pattern string `mapstructure:"pattern"`
+
+	// Retention is the value of the "retention" tag of the matched metrics.
+	Retention string `mapstructure:"retention"`
+}
+
+// StartRetryConfig defines how the exporter retries to start.
+type StartRetryConfig struct {
+	// Enabled turns on retrying to start. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Interval is the time waited between attempts. The default value is 1s.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// MaxElapsedTime is the maximum time spent retrying, after which the
+	// last error is returned. The default value is 1m, 0 retries until the
+	// start is cancelled.
+	MaxElapsedTime time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// MovingAverageConfig configures the moving average lines of gauges.
+type MovingAverageConfig struct {
+	// Enabled turns on the moving average lines. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Window is the number of points of a series, across the exports
+	// written, averaged in each line. The default value is 5.
+	Window int `mapstructure:"window"`
+
+	// StalenessTTL is how long after its last point the values of a series
+	// are forgotten. The default value is 10m.
+	StalenessTTL time.Duration `mapstructure:"staleness_ttl"`
+
+	// MaxSeries is the maximum number of series whose values are kept, the
+	// least recently updated ones are forgotten first. The default value is
+	// 10000.
+	MaxSeries int `mapstructure:"max_series"`
+}
+
+// UptimeConfig configures the uptime lines.
+type UptimeConfig struct {
+	// Enabled turns on the uptime lines. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Interval is the time between two uptime lines, the first one being
+	// sent one interval after the start. The default value is 1m.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// BackpressureDropsConfig configures the lines counting the data points
+// dropped due to backpressure.
+type BackpressureDropsConfig struct {
+	// Enabled turns on the backpressure drops lines. The default value is
+	// false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Interval is the time between two backpressure drops lines, the first
+	// one being sent one interval after the start. The default value is 1m.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// RepeatLastValueConfig configures the repetition of the last values of
+// gauges.
+type RepeatLastValueConfig struct {
+	// Enabled turns on the repetition. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Interval is the period of the repetitions, the last value of a series
+	// is repeated once it is at least Interval old. The default value is 1m.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// StalenessTTL is how long after the last new value of a series it stops
+	// being repeated. The default value is 10m.
+	StalenessTTL time.Duration `mapstructure:"staleness_ttl"`
+
+	// MaxSeries is the maximum number of series whose last value is kept,
+	// the least recently updated ones are forgotten first. The default value
+	// is 10000.
+	MaxSeries int `mapstructure:"max_series"`
+}
+
+// SumConversionConfig configures the conversion of the cumulative monotonic
+// sums.
+type SumConversionConfig struct {
+	// Mode is "none" to send the cumulative values, "delta" to send the
+	// increase since the previous point of the series or "rate" to send that
+	// increase per second. The first point of a series, and the first one
+	// after the counter restarted, is only kept as the reference of the next
+	// one. The points of a failed export only become the reference once its
+	// retry is written. The default value is "none".
+	Mode string `mapstructure:"mode"`
+
+	// StalenessTTL is how long after its last point the previous point of a
+	// series is forgotten. The default value is 10m.
+	StalenessTTL time.Duration `mapstructure:"staleness_ttl"`
+
+	// MaxSeries is the maximum number of series whose previous point is
+	// kept, the least recently updated ones are forgotten first. The default
+	// value is 10000.
+	MaxSeries int `mapstructure:"max_series"`
+}
+
+// ReconnectConfig configures the reconnections while writing a batch.
+type ReconnectConfig struct {
+	// Enabled turns on the reconnections. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// MaxAttempts is the maximum number of connections dialed to write a
+	// batch once its connection broke, failed dials included, after which
+	// the export fails. The default value is 3.
+	MaxAttempts int `mapstructure:"max_attempts"`
+
+	// InitialInterval is the time waited before the first attempt. The
+	// default value is 100ms.
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+
+	// MaxInterval bounds the time waited between two attempts. The default
+	// value is 5s.
+	MaxInterval time.Duration `mapstructure:"max_interval"`
+
+	// Multiplier is the factor by which the time waited grows after each
+	// attempt. The default value is 2.
+	Multiplier float64 `mapstructure:"multiplier"`
+}
+
+// EnvironmentInPathConfig configures the environment segment of the paths.
+type EnvironmentInPathConfig struct {
+	// Enabled turns on the environment segment. The default value is false.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Default is the segment of the metrics whose resource has no
+	// "deployment.environment" attribute, an empty default omits the
+	// segment. The default value is "unknown".
+	Default string `mapstructure:"default"`
 }
 
 func (cfg *Config) Validate() error {
 	// Resolve TCP address just to ensure that it is a valid one. It is better
 	// to fail here than at when the exporter is started.
-	if _, err := net.ResolveTCPAddr("tcp", cfg.Endpoint); err != nil {
-		return fmt.Errorf("exporter has an invalid TCP endpoint: %w", err)
+	switch cfg.Transport {
+	case "", transportTCP:
+		if _, err := net.ResolveTCPAddr("tcp", cfg.Endpoint); err != nil {
+			return fmt.Errorf("exporter has an invalid TCP endpoint: %w", err)
+		}
+	case transportUDP:
+		if _, err := net.ResolveUDPAddr("udp", cfg.Endpoint); err != nil {
+			return fmt.Errorf("exporter has an invalid UDP endpoint: %w", err)
+		}
+		if cfg.ReadinessProbeLine != "" {
+			return errors.New("exporter cannot use readiness_probe_line with the udp transport")
+		}
+		if cfg.TLSSetting != nil {
+			return errors.New("exporter cannot use tls with the udp transport")
+		}
+	case transportUnix:
+		if cfg.Endpoint == "" {
+			return errors.New("exporter requires the path of the Unix socket as endpoint")
+		}
+		if cfg.TLSSetting != nil {
+			return errors.New("exporter cannot use tls with the unix transport")
+		}
+	default:
+		return fmt.Errorf("exporter has an invalid transport: %q", cfg.Transport)
+	}
+
+	switch cfg.Encoding {
+	case "", encodingPlaintext:
+	case encodingPickle:
+		if cfg.Transport == transportUDP {
+			return errors.New("exporter cannot use the pickle encoding with the udp transport")
+		}
+		if cfg.FieldOrder != "" {
+			return errors.New("exporter cannot use field_order with the pickle encoding")
+		}
+		if cfg.ReadinessProbeLine != "" {
+			return errors.New("exporter cannot use readiness_probe_line with the pickle encoding")
+		}
+	default:
+		return fmt.Errorf("exporter has an invalid encoding: %q", cfg.Encoding)
+	}
+
+	switch cfg.NaNHandling {
+	case "", nanHandlingSkip, nanHandlingZero, nanHandlingPassthrough:
+	default:
+		return fmt.Errorf("exporter has an invalid nan_handling: %q", cfg.NaNHandling)
+	}
+
+	switch cfg.DefaultTemporality {
+	case "", temporalityCumulative, temporalityDelta:
+	default:
+		return fmt.Errorf("exporter has an invalid default_temporality: %q", cfg.DefaultTemporality)
+	}
+
+	switch cfg.MetricsFormat {
+	case "", metricsFormatTags, metricsFormatDotted:
+	default:
+		return fmt.Errorf("exporter has an invalid metrics_format: %q", cfg.MetricsFormat)
 	}
 
 	// Negative timeouts are not acceptable, since all sends will fail.
@@ -46,5 +771,345 @@ func (cfg *Config) Validate() error {
 		return errors.New("exporter requires a positive timeout")
 	}
 
+	if cfg.WriteTimeout < 0 {
+		return errors.New("exporter requires a non-negative write_timeout")
+	}
+
+	if cfg.MaxPointAge < 0 {
+		return errors.New("exporter requires a non-negative max_point_age")
+	}
+
+	switch cfg.TagKeyCollisionPolicy {
+	case "", tagKeyCollisionKeepFirst, tagKeyCollisionSuffix, tagKeyCollisionConcatValues:
+	default:
+		return fmt.Errorf("exporter has an invalid tag_key_collision_policy: %q", cfg.TagKeyCollisionPolicy)
+	}
+
+	if cfg.MinNonZeroValue < 0 {
+		return errors.New("exporter requires a non-negative min_non_zero_value")
+	}
+
+	if strings.ContainsAny(cfg.PipelineTag, " \t\r\n") {
+		return fmt.Errorf("exporter has an invalid pipeline_tag %q: whitespace is not allowed", cfg.PipelineTag)
+	}
+
+	for unit, precision := range cfg.PrecisionByUnit {
+		if precision < 0 {
+			return fmt.Errorf("exporter requires a non-negative precision_by_unit for unit %q", unit)
+		}
+	}
+
+	for _, pattern := range cfg.SuppressZeros {
+		if _, err := compileMetricNamePattern(pattern); err != nil {
+			return fmt.Errorf("exporter has an invalid suppress_zeros pattern %q: %w", pattern, err)
+		}
+	}
+
+	if cfg.MaxLinesPerExport < 0 {
+		return errors.New("exporter requires a non-negative max_lines_per_export")
+	}
+
+	if strings.ContainsAny(cfg.BatchSentinel, " \t\r\n") {
+		return fmt.Errorf("exporter has an invalid batch_sentinel %q: whitespace is not allowed", cfg.BatchSentinel)
+	}
+
+	if cfg.ResourceAttributesAsJSONTag && cfg.ResourceToTelemetryConfig.Enabled {
+		return errors.New("exporter cannot enable both resource_attributes_as_json_tag and resource_to_telemetry_conversion")
+	}
+
+	if len(cfg.ResourceAttributesAsTags) > 0 && cfg.ResourceToTelemetryConfig.Enabled {
+		return errors.New("exporter cannot enable both resource_attributes_as_tags and resource_to_telemetry_conversion")
+	}
+	if len(cfg.HashTagValues) > 0 && cfg.HashTagValuesSalt == "" {
+		return errors.New("exporter requires a hash_tag_values_salt with hash_tag_values")
+	}
+	for _, key := range cfg.HashTagValues {
+		if key == "" {
+			return errors.New("exporter has an empty hash_tag_values key")
+		}
+	}
+
+	for _, key := range cfg.ResourceAttributesAsTags {
+		if !isValidTagKey(key) {
+			return fmt.Errorf("exporter has an invalid resource_attributes_as_tags key %q", key)
+		}
+	}
+
+	if cfg.UnhealthyThreshold < 0 {
+		return errors.New("exporter requires a non-negative unhealthy_threshold")
+	}
+
+	if strings.ContainsAny(cfg.ReadinessProbeLine, "\r\n") {
+		return errors.New("exporter requires a readiness_probe_line without line breaks")
+	}
+
+	if err := validateSeparator("tag_separator", cfg.TagSeparator); err != nil {
+		return err
+	}
+	if err := validateSeparator("tag_kv_separator", cfg.TagKVSeparator); err != nil {
+		return err
+	}
+	// Empty separators stand for their default.
+	tagSeparator := stringOrDefault(cfg.TagSeparator, defaultTagSeparator)
+	tagKVSeparator := stringOrDefault(cfg.TagKVSeparator, defaultTagKVSeparator)
+	separator := stringOrDefault(cfg.Separator, pathSeparator)
+	if tagSeparator == tagKVSeparator {
+		return errors.New("exporter requires different tag_separator and tag_kv_separator")
+	}
+	if err := validateSeparator("separator", cfg.Separator); err != nil {
+		return err
+	}
+	if separator == tagSeparator {
+		return errors.New("exporter requires different separator and tag_separator")
+	}
+
+	switch cfg.ShutdownDrainPolicy {
+	case "", shutdownDrainFlush, shutdownDrainDrop:
+	default:
+		return fmt.Errorf("exporter has an invalid shutdown_drain_policy: %q", cfg.ShutdownDrainPolicy)
+	}
+
+	if cfg.ShutdownDrainTimeout < 0 {
+		return errors.New("exporter requires a non-negative shutdown_drain_timeout")
+	}
+	if cfg.ShutdownGracePeriod < 0 {
+		return errors.New("exporter requires a non-negative shutdown_grace_period")
+	}
+
+	if strings.ContainsAny(cfg.MonotonicSuffix, " \t\r\n") {
+		return fmt.Errorf("exporter has an invalid monotonic_suffix %q: whitespace is not allowed", cfg.MonotonicSuffix)
+	}
+
+	if cfg.StartRetry.Enabled {
+		if cfg.StartRetry.Interval <= 0 {
+			return errors.New("exporter requires a positive start_retry::interval")
+		}
+		if cfg.StartRetry.MaxElapsedTime < 0 {
+			return errors.New("exporter requires a non-negative start_retry::max_elapsed_time")
+		}
+	}
+
+	if cfg.Reconnect.Enabled {
+		if cfg.Reconnect.MaxAttempts <= 0 {
+			return errors.New("exporter requires a positive reconnect::max_attempts")
+		}
+		if cfg.Reconnect.InitialInterval <= 0 {
+			return errors.New("exporter requires a positive reconnect::initial_interval")
+		}
+		if cfg.Reconnect.MaxInterval < cfg.Reconnect.InitialInterval {
+			return errors.New("exporter requires a reconnect::max_interval greater than or equal to reconnect::initial_interval")
+		}
+		if cfg.Reconnect.Multiplier < 1 {
+			return errors.New("exporter requires a reconnect::multiplier greater than or equal to 1")
+		}
+	}
+
+	if cfg.PickleMaxFrameBytes < 0 {
+		return errors.New("exporter requires a non-negative pickle_max_frame_bytes")
+	}
+
+	if cfg.MaxConnections < 0 {
+		return errors.New("exporter requires a non-negative max_connections")
+	}
+
+	if cfg.KeepAlivePeriod > 0 && cfg.KeepAlivePeriod < time.Second {
+		return fmt.Errorf("exporter requires a keep_alive_period of at least 1s, got %s", cfg.KeepAlivePeriod)
+	}
+	if cfg.Linger != nil && (*cfg.Linger < 0 || *cfg.Linger%time.Second != 0) {
+		return fmt.Errorf("exporter requires a non-negative linger in whole seconds, got %s", *cfg.Linger)
+	}
+
+	if cfg.EmitMovingAverage.Enabled {
+		if cfg.EmitMovingAverage.Window <= 0 {
+			return errors.New("exporter requires a positive emit_moving_average::window")
+		}
+		if cfg.EmitMovingAverage.StalenessTTL <= 0 {
+			return errors.New("exporter requires a positive emit_moving_average::staleness_ttl")
+		}
+		if cfg.EmitMovingAverage.MaxSeries <= 0 {
+			return errors.New("exporter requires a positive emit_moving_average::max_series")
+		}
+	}
+
+	if strings.IndexFunc(cfg.SanitizeReplacement, isLineControlRune) >= 0 {
+		return errors.New("exporter requires a sanitize_replacement without whitespaces, \";\" nor \"=\"")
+	}
+	if cfg.SanitizeNames && cfg.SanitizeReplacement != "" && (strings.Contains(cfg.SanitizeReplacement, tagSeparator) || strings.Contains(cfg.SanitizeReplacement, tagKVSeparator)) {
+		return errors.New("exporter requires a sanitize_replacement without the tag separators")
+	}
+
+	if cfg.PathTemplate != "" {
+		if err := validatePathTemplate(cfg.PathTemplate); err != nil {
+			return fmt.Errorf("exporter has an invalid path_template: %w", err)
+		}
+	}
+
+	for _, metricType := range cfg.MetricTypes {
+		if _, ok := convertedMetricTypes[metricType]; !ok {
+			return fmt.Errorf("exporter has an invalid metric_types entry: %q", metricType)
+		}
+	}
+
+	if strings.ContainsAny(cfg.IntervalAttribute, " \t\r\n") {
+		return fmt.Errorf("exporter has an invalid interval_attribute %q: whitespace is not allowed", cfg.IntervalAttribute)
+	}
+
+	if cfg.EmitUptime.Enabled && cfg.EmitUptime.Interval <= 0 {
+		return errors.New("exporter requires a positive emit_uptime::interval")
+	}
+
+	if cfg.SeriesCountInterval < 0 {
+		return errors.New("exporter requires a non-negative series_count_interval")
+	}
+	if cfg.SeriesCountInterval > 0 && cfg.SeriesCountMaxSeries <= 0 {
+		return errors.New("exporter requires a positive series_count_max_series")
+	}
+
+	if cfg.EmitBackpressureDrops.Enabled {
+		if cfg.EmitBackpressureDrops.Interval <= 0 {
+			return errors.New("exporter requires a positive emit_backpressure_drops::interval")
+		}
+		if !cfg.QueueConfig.Enabled {
+			return errors.New("exporter requires the sending_queue to emit_backpressure_drops")
+		}
+	}
+
+	if cfg.RepeatLastValue.Enabled {
+		if cfg.RepeatLastValue.Interval <= 0 {
+			return errors.New("exporter requires a positive repeat_last_value::interval")
+		}
+		if cfg.RepeatLastValue.StalenessTTL <= 0 {
+			return errors.New("exporter requires a positive repeat_last_value::staleness_ttl")
+		}
+		if cfg.RepeatLastValue.MaxSeries <= 0 {
+			return errors.New("exporter requires a positive repeat_last_value::max_series")
+		}
+	}
+
+	switch cfg.SumConversion.Mode {
+	case "", sumConversionNone:
+	case sumConversionDelta, sumConversionRate:
+		if cfg.SumConversion.StalenessTTL <= 0 {
+			return errors.New("exporter requires a positive sum_conversion::staleness_ttl")
+		}
+		if cfg.SumConversion.MaxSeries <= 0 {
+			return errors.New("exporter requires a positive sum_conversion::max_series")
+		}
+	default:
+		return fmt.Errorf("exporter has an invalid sum_conversion::mode: %q", cfg.SumConversion.Mode)
+	}
+
+	if cfg.CrossExportDedupWindow < 0 {
+		return errors.New("exporter requires a non-negative cross_export_dedup_window")
+	}
+	if cfg.CrossExportDedupWindow > 0 && cfg.CrossExportDedupMaxEntries <= 0 {
+		return errors.New("exporter requires a positive cross_export_dedup_max_entries")
+	}
+	switch cfg.ConcurrentDuplicatePolicy {
+	case "", duplicatePolicyDrop:
+	case duplicatePolicyLast, duplicatePolicyMax, duplicatePolicySum:
+		if cfg.CrossExportDedupWindow == 0 {
+			return fmt.Errorf("exporter requires a positive cross_export_dedup_window for the concurrent_duplicate_policy %q", cfg.ConcurrentDuplicatePolicy)
+		}
+	default:
+		return fmt.Errorf("exporter has an invalid concurrent_duplicate_policy: %q", cfg.ConcurrentDuplicatePolicy)
+	}
+
+	if cfg.StartupJitter < 0 {
+		return errors.New("exporter requires a non-negative startup_jitter")
+	}
+
+	for key, value := range cfg.GeoTags {
+		if !isValidTagKey(key) {
+			return fmt.Errorf("exporter has an invalid geo_tags key %q", key)
+		}
+		if !isValidTagValue(value) {
+			return fmt.Errorf("exporter has an invalid geo_tags value %q for key %q", value, key)
+		}
+	}
+
+	for name, tags := range cfg.MetricTags {
+		for key, value := range tags {
+			if !isValidTagKey(key) {
+				return fmt.Errorf("exporter has an invalid metric_tags key %q for metric %q", key, name)
+			}
+			if !isValidTagValue(value) {
+				return fmt.Errorf("exporter has an invalid metric_tags value %q for key %q of metric %q", value, key, name)
+			}
+		}
+	}
+
+	for _, class := range cfg.RetentionClasses {
+		if _, err := compileMetricNamePattern(class.Pattern); err != nil {
+			return fmt.Errorf("exporter has an invalid retention_classes pattern %q: %w", class.Pattern, err)
+		}
+		if !isValidTagValue(class.Retention) {
+			return fmt.Errorf("exporter has an invalid retention_classes retention %q", class.Retention)
+		}
+	}
+
+	for _, window := range cfg.MaintenanceWindows {
+		if window.Start.IsZero() || !window.Start.Before(window.End) {
+			return fmt.Errorf("exporter has an invalid maintenance_windows window from %q to %q", window.Start, window.End)
+		}
+	}
+
+	switch cfg.UnicodePolicy {
+	case "", unicodePolicyKeep, unicodePolicyASCIITransliterate, unicodePolicyStrip:
+	default:
+		return fmt.Errorf("exporter has an invalid unicode_policy: %q", cfg.UnicodePolicy)
+	}
+
+	if cfg.MTU < 0 {
+		return errors.New("exporter requires a non-negative mtu")
+	}
+
+	if strings.ContainsAny(cfg.EnvironmentInPath.Default, " \t\r\n"+separator) {
+		return fmt.Errorf("exporter has an invalid environment_in_path::default %q: whitespace and separators are not allowed", cfg.EnvironmentInPath.Default)
+	}
+
+	if strings.ContainsAny(cfg.ResourcelessPrefix, " \t\r\n") || strings.HasPrefix(cfg.ResourcelessPrefix, separator) || strings.HasSuffix(cfg.ResourcelessPrefix, separator) {
+		return fmt.Errorf("exporter has an invalid resourceless_prefix %q: whitespace and leading or trailing separators are not allowed", cfg.ResourcelessPrefix)
+	}
+
+	if strings.ContainsAny(cfg.Prefix, " \t\r\n") || strings.HasPrefix(cfg.Prefix, separator) || strings.Contains(cfg.Prefix, separator+separator) {
+		return fmt.Errorf("exporter has an invalid prefix %q: whitespace, leading or repeated separators are not allowed", cfg.Prefix)
+	}
+
+	if cfg.FieldOrder != "" {
+		if _, err := parseFieldOrder(cfg.FieldOrder); err != nil {
+			return fmt.Errorf("exporter has an invalid field_order: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateSeparator checks that a separator can't break the
+// "<path> <value> <timestamp>" structure of the lines. An empty separator
+// stands for its default.
+func validateSeparator(name, separator string) error {
+	if strings.ContainsAny(separator, " \t\r\n") {
+		return fmt.Errorf("exporter has an invalid %s %q: whitespace is not allowed", name, separator)
+	}
 	return nil
 }
+
+// stringOrDefault returns value, or def when value is empty.
+func stringOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// isValidTagKey reports whether key can be written as a tag key unchanged.
+func isValidTagKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, " \t\r\n") && sanitizeTagKey(key) == key
+}
+
+// isValidTagValue reports whether value can be written as a tag value
+// unchanged.
+func isValidTagValue(value string) bool {
+	return value != "" && !strings.ContainsAny(value, " \t\r\n") && sanitizeTagValue(value) == value
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/confignet"
+	"go.opentelemetry.io/collector/config/configtls"
 	"go.opentelemetry.io/collector/confmap/confmaptest"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 
@@ -41,6 +42,12 @@ func TestLoadConfig(t *testing.T) {
 				TCPAddr: confignet.TCPAddr{
 					Endpoint: "localhost:8080",
 				},
+				TLSSetting: &configtls.TLSClientSetting{
+					TLSSetting: configtls.TLSSetting{
+						CAFile: "ca.crt",
+					},
+					ServerName: "carbon.example.com",
+				},
 				TimeoutSettings: exporterhelper.TimeoutSettings{
 					Timeout: 10 * time.Second,
 				},
@@ -60,6 +67,104 @@ func TestLoadConfig(t *testing.T) {
 				ResourceToTelemetryConfig: resourcetotelemetry.Settings{
 					Enabled: true,
 				},
+				TagKeyCollisionPolicy: tagKeyCollisionSuffix,
+				MaxPointAge:           time.Hour,
+				FieldOrder:            "{timestamp} {name} {value}",
+				AggregationMethodTag:  true,
+				EscapeSeparatorInName: true,
+				MinNonZeroValue:       1e-6,
+				PipelineTag:           "primary",
+				PrecisionByUnit:       map[string]int{"ms": 3, "1": 0},
+				SuppressZeros:         []string{`errors\..*`},
+				EmitDropSamples:       true,
+				DeterministicOrder:    true,
+				MaxLinesPerExport:     1000,
+				BatchSentinel:         "carbon.batch",
+				KubernetesTags:        true,
+				UnhealthyThreshold:    3,
+				ReadinessProbeLine:    "ping",
+				TagSeparator:          ",",
+				TagKVSeparator:        ":",
+				EmitScopeMeta:         true,
+				ShutdownDrainPolicy:   shutdownDrainDrop,
+				ShutdownDrainTimeout:  30 * time.Second,
+				ShutdownGracePeriod:   10 * time.Second,
+				MonotonicSuffix:       ".total",
+				StartRetry: StartRetryConfig{
+					Enabled:        true,
+					Interval:       5 * time.Second,
+					MaxElapsedTime: 2 * time.Minute,
+				},
+				StartupJitter:     30 * time.Second,
+				MaxConnections:    4,
+				Linger:            durationPtr(5 * time.Second),
+				KeepAlivePeriod:   30 * time.Second,
+				GeoTags:           map[string]string{"region": "us-east-1", "zone": "a"},
+				HashTagValues:     []string{"user.id", "user.email"},
+				HashTagValuesSalt: "s3cr3t",
+				MetricTags: map[string]map[string]string{
+					"http.server.duration": {"team": "web"},
+				},
+				RetentionClasses: []RetentionClass{
+					{Pattern: `debug\..*`, Retention: "short"},
+					{Pattern: `.*`, Retention: "long"},
+				},
+				MaintenanceWindows: []MaintenanceWindow{
+					{
+						Start: time.Date(2023, 12, 1, 22, 0, 0, 0, time.UTC),
+						End:   time.Date(2023, 12, 2, 2, 0, 0, 0, time.UTC),
+					},
+				},
+				UnicodePolicy:              unicodePolicyASCIITransliterate,
+				EmitShutdownMarker:         true,
+				Transport:                  transportTCP,
+				MTU:                        1400,
+				ResourcelessPrefix:         "unknown_service",
+				MergeHistogramFragments:    true,
+				Encoding:                   encodingPlaintext,
+				PickleMaxFrameBytes:        65536,
+				Prefix:                     "prod.collector",
+				Separator:                  "/",
+				CrossExportDedupWindow:     time.Minute,
+				CrossExportDedupMaxEntries: 1000,
+				ConcurrentDuplicatePolicy:  duplicatePolicyMax,
+				EmitMovingAverage:          MovingAverageConfig{Enabled: true, Window: 3, StalenessTTL: 10 * time.Minute, MaxSeries: 10000},
+				MetricsFormat:              metricsFormatDotted,
+				MetricTypes:                []string{"gauge", "sum"},
+				IntervalAttribute:          "scrape_interval",
+				PathTemplate:               `{{index .ResourceAttributes "deployment.environment"}}.{{.MetricName}}`,
+				EmitUptime:                 UptimeConfig{Enabled: true, Interval: 30 * time.Second},
+				EmitBackpressureDrops:      BackpressureDropsConfig{Enabled: true, Interval: 30 * time.Second},
+				SeriesCountInterval:        time.Minute,
+				SeriesCountMaxSeries:       5000,
+				SanitizeNames:              true,
+				SanitizeReplacement:        "-",
+				RepeatLastValue: RepeatLastValueConfig{
+					Enabled:      true,
+					Interval:     30 * time.Second,
+					StalenessTTL: 5 * time.Minute,
+					MaxSeries:    1000,
+				},
+				SumConversion: SumConversionConfig{
+					Mode:         sumConversionRate,
+					StalenessTTL: 15 * time.Minute,
+					MaxSeries:    500,
+				},
+				NaNHandling:            nanHandlingZero,
+				DefaultTemporality:     temporalityDelta,
+				IncludeScopeVersionTag: true,
+				IncludeScope:           true,
+				IncludeScopeAttributes: true,
+				StrictShutdown:         true,
+				WriteTimeout:           30 * time.Second,
+				EnvironmentInPath:      EnvironmentInPathConfig{Enabled: true, Default: "none"},
+				Reconnect: ReconnectConfig{
+					Enabled:         true,
+					MaxAttempts:     5,
+					InitialInterval: 200 * time.Millisecond,
+					MaxInterval:     10 * time.Second,
+					Multiplier:      1.5,
+				},
 			},
 		},
 	}
@@ -89,6 +194,15 @@ func TestValidateConfig(t *testing.T) {
 			name:   "default_config",
 			config: createDefaultConfig().(*Config),
 		},
+		{
+			// The empty enums and separators stand for their default.
+			name: "zero_value_config",
+			config: &Config{
+				TCPAddr: confignet.TCPAddr{
+					Endpoint: "localhost:2003",
+				},
+			},
+		},
 		{
 			name: "invalid_tcp_addr",
 			config: &Config{
@@ -107,6 +221,780 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid_max_point_age",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MaxPointAge = -time.Minute
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_field_order",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.FieldOrder = "{name} {value}"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_tag_key_collision_policy",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.TagKeyCollisionPolicy = "unknown"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_min_non_zero_value",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MinNonZeroValue = -1e-6
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_pipeline_tag",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.PipelineTag = "carbon primary"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_precision_by_unit",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.PrecisionByUnit = map[string]int{"ms": -1}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_suppress_zeros",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SuppressZeros = []string{"errors.("}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_max_lines_per_export",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MaxLinesPerExport = -1
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_batch_sentinel",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.BatchSentinel = "batch end"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "resource_json_tag_with_resource_to_telemetry",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ResourceAttributesAsJSONTag = true
+				cfg.ResourceToTelemetryConfig.Enabled = true
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "resource_attributes_as_tags_with_resource_to_telemetry",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ResourceAttributesAsTags = []string{"service.name"}
+				cfg.ResourceToTelemetryConfig.Enabled = true
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "hash_tag_values_without_salt",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.HashTagValues = []string{"user.id"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "empty_hash_tag_values_key",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.HashTagValues = []string{""}
+				cfg.HashTagValuesSalt = "s3cr3t"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_resource_attributes_as_tags_key",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ResourceAttributesAsTags = []string{"service;name"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_unhealthy_threshold",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.UnhealthyThreshold = -1
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "multiline_readiness_probe_line",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ReadinessProbeLine = "ping\nping"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "tag_kv_separator_as_default_tag_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.TagSeparator = ""
+				cfg.TagKVSeparator = ";"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_tag_kv_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.TagKVSeparator = " "
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "identical_tag_separators",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.TagSeparator = ","
+				cfg.TagKVSeparator = ","
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Separator = " "
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "separator_is_tag_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Separator = ";"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_shutdown_drain_policy",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ShutdownDrainPolicy = "wait"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_shutdown_grace_period",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ShutdownGracePeriod = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_shutdown_drain_timeout",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ShutdownDrainTimeout = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_monotonic_suffix",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MonotonicSuffix = " total"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "zero_start_retry_interval",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.StartRetry.Enabled = true
+				cfg.StartRetry.Interval = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_start_retry_max_elapsed_time",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.StartRetry.Enabled = true
+				cfg.StartRetry.MaxElapsedTime = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_max_connections",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MaxConnections = -1
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "keep_alive_period_below_a_second",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.KeepAlivePeriod = 500 * time.Millisecond
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "linger_not_in_seconds",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Linger = durationPtr(500 * time.Millisecond)
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_cross_export_dedup_window",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.CrossExportDedupWindow = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "cross_export_dedup_without_max_entries",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.CrossExportDedupWindow = time.Minute
+				cfg.CrossExportDedupMaxEntries = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_concurrent_duplicate_policy",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.CrossExportDedupWindow = time.Minute
+				cfg.ConcurrentDuplicatePolicy = "first"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "concurrent_duplicate_policy_without_dedup_window",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ConcurrentDuplicatePolicy = duplicatePolicySum
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_metrics_format",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MetricsFormat = "json"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_path_template",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.PathTemplate = "{{.MetricName"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "path_template_failing_to_render",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.PathTemplate = "{{.Name}}"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_metric_types",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MetricTypes = []string{"gauge", "exponential_histogram"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "whitespace_interval_attribute",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.IntervalAttribute = "scrape interval"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_uptime_without_interval",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EmitUptime = UptimeConfig{Enabled: true}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_series_count_interval",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SeriesCountInterval = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "series_count_without_max_series",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SeriesCountInterval = time.Minute
+				cfg.SeriesCountMaxSeries = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_backpressure_drops_without_interval",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EmitBackpressureDrops = BackpressureDropsConfig{Enabled: true}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_backpressure_drops_without_queue",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.QueueConfig.Enabled = false
+				cfg.EmitBackpressureDrops = BackpressureDropsConfig{Enabled: true, Interval: time.Minute}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sanitize_replacement_with_tag_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.TagSeparator = ","
+				cfg.SanitizeReplacement = ","
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sanitize_replacement_with_space",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SanitizeReplacement = " "
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "repeat_last_value_without_staleness_ttl",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.RepeatLastValue.Enabled = true
+				cfg.RepeatLastValue.StalenessTTL = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "repeat_last_value_without_max_series",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.RepeatLastValue.Enabled = true
+				cfg.RepeatLastValue.MaxSeries = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_sum_conversion_mode",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SumConversion.Mode = "derivative"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sum_conversion_without_staleness_ttl",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SumConversion.Mode = sumConversionDelta
+				cfg.SumConversion.StalenessTTL = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "sum_conversion_without_max_series",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.SumConversion.Mode = sumConversionRate
+				cfg.SumConversion.MaxSeries = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_nan_handling",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.NaNHandling = "null"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_write_timeout",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.WriteTimeout = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_environment_in_path_default",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EnvironmentInPath = EnvironmentInPathConfig{Enabled: true, Default: "no.env"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "reconnect_max_interval_below_initial_interval",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Reconnect.Enabled = true
+				cfg.Reconnect.MaxInterval = cfg.Reconnect.InitialInterval / 2
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "reconnect_multiplier_below_one",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Reconnect.Enabled = true
+				cfg.Reconnect.Multiplier = 0.5
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_default_temporality",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.DefaultTemporality = "unspecified"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_moving_average_without_window",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EmitMovingAverage = MovingAverageConfig{Enabled: true}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_moving_average_without_staleness_ttl",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EmitMovingAverage.Enabled = true
+				cfg.EmitMovingAverage.StalenessTTL = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "emit_moving_average_without_max_series",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.EmitMovingAverage.Enabled = true
+				cfg.EmitMovingAverage.MaxSeries = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative_startup_jitter",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.StartupJitter = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_geo_tags_key",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.GeoTags = map[string]string{"region=": "us-east-1"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "empty_geo_tags_value",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.GeoTags = map[string]string{"region": ""}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_metric_tags_value",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MetricTags = map[string]map[string]string{"http.server.duration": {"team": "web team"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_retention_classes_pattern",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.RetentionClasses = []RetentionClass{{Pattern: "debug.(", Retention: "short"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "empty_retention_classes_retention",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.RetentionClasses = []RetentionClass{{Pattern: "debug.*"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "maintenance_window_ending_before_start",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MaintenanceWindows = []MaintenanceWindow{{
+					Start: time.Date(2023, 12, 2, 2, 0, 0, 0, time.UTC),
+					End:   time.Date(2023, 12, 1, 22, 0, 0, 0, time.UTC),
+				}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_unicode_policy",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.UnicodePolicy = "escape"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "udp_transport",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Transport = transportUDP
+				return cfg
+			}(),
+		},
+		{
+			name: "invalid_transport",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Transport = "quic"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "udp_transport_with_readiness_probe_line",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Transport = transportUDP
+				cfg.ReadinessProbeLine = "ping"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pickle_encoding",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Encoding = encodingPickle
+				return cfg
+			}(),
+		},
+		{
+			name: "invalid_encoding",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Encoding = "protobuf"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pickle_encoding_with_udp_transport",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Encoding = encodingPickle
+				cfg.Transport = transportUDP
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pickle_encoding_with_field_order",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Encoding = encodingPickle
+				cfg.FieldOrder = "{timestamp} {name} {value}"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pickle_encoding_with_readiness_probe_line",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Encoding = encodingPickle
+				cfg.ReadinessProbeLine = "ping"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "udp_transport_with_tls",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Transport = transportUDP
+				cfg.TLSSetting = &configtls.TLSClientSetting{}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "unix_transport_with_tls",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Endpoint = "/var/run/carbon.sock"
+				cfg.Transport = transportUnix
+				cfg.TLSSetting = &configtls.TLSClientSetting{}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_prefix_leading_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Prefix = ".prod"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid_prefix_repeated_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Prefix = "prod..collector"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "prefix_trailing_separator",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.Prefix = "prod."
+				return cfg
+			}(),
+		},
+		{
+			name: "negative_mtu",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.MTU = -1
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "trailing_separator_resourceless_prefix",
+			config: func() *Config {
+				cfg := createDefaultConfig().(*Config)
+				cfg.ResourcelessPrefix = "unknown."
+				return cfg
+			}(),
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -118,3 +1006,7 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
@@ -0,0 +1,30 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"errors"
+	"syscall"
+)
+
+// peerClosed reports whether the server closed the connection, peeking at the
+// socket without blocking nor consuming any data.
+func peerClosed(rawConn syscall.RawConn) bool {
+	closed := false
+	err := rawConn.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK)
+		if err != nil {
+			closed = !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.EWOULDBLOCK)
+		} else {
+			closed = n == 0
+		}
+		// Never wait for the socket to be readable.
+		return true
+	})
+	return err != nil || closed
+}
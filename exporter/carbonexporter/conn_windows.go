@@ -0,0 +1,17 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"syscall"
+)
+
+// peerClosed always reports the connection as open, checking it is not
+// supported on Windows.
+func peerClosed(syscall.RawConn) bool {
+	return false
+}
@@ -0,0 +1,121 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// Supported values for SumConversionConfig.Mode.
+const (
+	sumConversionNone  = "none"
+	sumConversionDelta = "delta"
+	sumConversionRate  = "rate"
+)
+
+// cumulativeSums remembers the previous point of each cumulative sum series,
+// identified by its path, to convert the next one into a delta. A series is
+// forgotten once it wasn't updated for staleAfter. At most maxSeries series
+// are remembered, the least recently updated ones are forgotten first.
+type cumulativeSums struct {
+	mu         sync.Mutex
+	staleAfter time.Duration
+	maxSeries  int
+	// entries holds the cumulativePoint values by increasing updatedAt.
+	entries *list.List
+	series  map[string]*list.Element
+}
+
+type cumulativePoint struct {
+	path      string
+	start     pcommon.Timestamp
+	timestamp pcommon.Timestamp
+	value     float64
+	updatedAt time.Time
+}
+
+func newCumulativeSums(staleAfter time.Duration, maxSeries int) *cumulativeSums {
+	return &cumulativeSums{
+		staleAfter: staleAfter,
+		maxSeries:  maxSeries,
+		entries:    list.New(),
+		series:     make(map[string]*list.Element),
+	}
+}
+
+// delta returns the increase of the series with the given path since its
+// previous point, the one in pending if the export being formatted has one,
+// and the time elapsed between both. It returns false for the first point of a
+// series and the first one after a reset, and for points not newer than the
+// previous one, which are ignored. The points not ignored are added to pending
+// and only become the reference of the next exports once record applies them.
+func (cs *cumulativeSums) delta(path string, start, timestamp pcommon.Timestamp, value float64, pending map[string]cumulativePoint, now time.Time) (float64, time.Duration, bool) {
+	prev, ok := pending[path]
+	if !ok {
+		prev, ok = cs.previous(path, now)
+	}
+	if ok && timestamp <= prev.timestamp {
+		return 0, 0, false
+	}
+	pending[path] = cumulativePoint{path: path, start: start, timestamp: timestamp, value: value}
+	if !ok || start != prev.start || value < prev.value {
+		// There is nothing to compare with, or the counter restarted.
+		return 0, 0, false
+	}
+	return value - prev.value, timestamp.AsTime().Sub(prev.timestamp.AsTime()), true
+}
+
+// previous returns the last point recorded for the series with the given
+// path, unless it is stale.
+func (cs *cumulativeSums) previous(path string, now time.Time) (cumulativePoint, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	e, ok := cs.series[path]
+	if !ok {
+		return cumulativePoint{}, false
+	}
+	point := e.Value.(cumulativePoint)
+	if now.Sub(point.updatedAt) >= cs.staleAfter {
+		return cumulativePoint{}, false
+	}
+	return point, true
+}
+
+// record makes the points of a written export, by path, the previous points of
+// their series as of now. A point older than the one recorded, written by a
+// concurrent export, is skipped.
+func (cs *cumulativeSums) record(points map[string]cumulativePoint, now time.Time) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for e := cs.entries.Front(); e != nil && now.Sub(e.Value.(cumulativePoint).updatedAt) >= cs.staleAfter; e = cs.entries.Front() {
+		cs.remove(e)
+	}
+
+	for path, point := range points {
+		point.updatedAt = now
+		if e, ok := cs.series[path]; ok {
+			if point.timestamp <= e.Value.(cumulativePoint).timestamp {
+				continue
+			}
+			e.Value = point
+			cs.entries.MoveToBack(e)
+			continue
+		}
+		cs.series[path] = cs.entries.PushBack(point)
+		if cs.entries.Len() > cs.maxSeries {
+			cs.remove(cs.entries.Front())
+		}
+	}
+}
+
+func (cs *cumulativeSums) remove(e *list.Element) {
+	delete(cs.series, e.Value.(cumulativePoint).path)
+	cs.entries.Remove(e)
+}
@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+func TestCumulativeSums(t *testing.T) {
+	start := time.Unix(0, 0)
+	ts := func(seconds int64) pcommon.Timestamp {
+		return pcommon.NewTimestampFromTime(time.Unix(seconds, 0))
+	}
+	cs := newCumulativeSums(time.Minute, 2)
+	// export formats a point in an export of its own, written.
+	export := func(path string, start, timestamp pcommon.Timestamp, value float64, now time.Time) (float64, time.Duration, bool) {
+		pending := make(map[string]cumulativePoint)
+		delta, elapsed, ok := cs.delta(path, start, timestamp, value, pending, now)
+		cs.record(pending, now)
+		return delta, elapsed, ok
+	}
+
+	// The first point is only the reference of the next one.
+	_, _, ok := export("a", ts(0), ts(10), 5, start)
+	assert.False(t, ok)
+	delta, elapsed, ok := export("a", ts(0), ts(20), 8, start)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, delta)
+	assert.Equal(t, 10*time.Second, elapsed)
+
+	// Points not newer than the previous one are ignored.
+	_, _, ok = export("a", ts(0), ts(20), 9, start)
+	assert.False(t, ok)
+	delta, _, ok = export("a", ts(0), ts(30), 9, start)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, delta)
+
+	// The points of an export not recorded are not the reference of the
+	// next exports.
+	_, _, ok = cs.delta("a", ts(0), ts(35), 10, make(map[string]cumulativePoint), start)
+	assert.True(t, ok)
+	delta, _, ok = export("a", ts(0), ts(35), 10, start)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, delta)
+
+	// The points of the same export are compared with each other.
+	pending := make(map[string]cumulativePoint)
+	_, _, ok = cs.delta("a", ts(0), ts(36), 12, pending, start)
+	assert.True(t, ok)
+	delta, _, ok = cs.delta("a", ts(0), ts(37), 15, pending, start)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, delta)
+	cs.record(pending, start)
+
+	// A restarted counter starts over.
+	_, _, ok = export("a", ts(38), ts(40), 2, start)
+	assert.False(t, ok)
+	delta, _, ok = export("a", ts(38), ts(50), 4, start)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, delta)
+
+	// Stale series are forgotten.
+	_, _, ok = export("a", ts(38), ts(60), 6, start.Add(time.Minute))
+	assert.False(t, ok)
+
+	// The least recently updated series are forgotten beyond maxSeries.
+	now := start.Add(2 * time.Minute)
+	export("b", ts(0), ts(10), 1, now)
+	export("c", ts(0), ts(10), 1, now)
+	export("a", ts(38), ts(70), 7, now)
+	_, _, ok = export("b", ts(0), ts(20), 2, now)
+	assert.False(t, ok)
+	_, _, ok = export("a", ts(38), ts(80), 8, now)
+	assert.True(t, ok)
+}
@@ -0,0 +1,201 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// dedupCache remembers the lines written in the last window, identified by
+// their path and timestamp, so the ones written again by later exports are
+// dropped, or resolved per the duplicate policy. At most maxEntries lines are
+// remembered, the oldest ones are forgotten first.
+type dedupCache struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	policy     string
+	// entries holds the *dedupEntry values in the order they were recorded.
+	entries *list.List
+	keys    map[string]*list.Element
+	// lastExport is the id of the last export started.
+	lastExport uint64
+}
+
+type dedupEntry struct {
+	key        string
+	recordedAt time.Time
+	// written is set once an export wrote the line, value then holds the
+	// value resolved from the written ones. Both are only used by resolve.
+	written bool
+	value   float64
+	// pending holds, by export, the values resolved by the exports not
+	// written yet.
+	pending map[uint64]float64
+}
+
+// dedupExport identifies the lines of an export, by their keys, to record
+// them once written or forget them if the export fails.
+type dedupExport struct {
+	id   uint64
+	keys []string
+}
+
+func newDedupCache(window time.Duration, maxEntries int, policy string) *dedupCache {
+	return &dedupCache{
+		window:     window,
+		maxEntries: maxEntries,
+		policy:     policy,
+		entries:    list.New(),
+		keys:       make(map[string]*list.Element),
+	}
+}
+
+// newExport returns the id of a new export, to pass to resolve.
+func (c *dedupCache) newExport() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastExport++
+	return c.lastExport
+}
+
+// seen reports whether the line with the given key was recorded within the
+// window before now.
+func (c *dedupCache) seen(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+	_, ok := c.keys[key]
+	return ok
+}
+
+// record remembers the lines of the export as written at now. Keys already
+// recorded keep their original time, so the window starts with the first
+// write.
+func (c *dedupCache) record(export dedupExport, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+	for _, key := range export.keys {
+		e, ok := c.keys[key]
+		if !ok {
+			// Lines resolved by the export but forgotten since can't be
+			// recorded with their value.
+			if c.policy == "" || c.policy == duplicatePolicyDrop {
+				c.add(&dedupEntry{key: key, recordedAt: now})
+			}
+			continue
+		}
+		entry := e.Value.(*dedupEntry)
+		value, ok := entry.pending[export.id]
+		if !ok {
+			continue
+		}
+		delete(entry.pending, export.id)
+		switch {
+		case !entry.written, c.policy == duplicatePolicyLast:
+			entry.value = value
+		case c.policy == duplicatePolicyMax:
+			entry.value = math.Max(entry.value, value)
+		case c.policy == duplicatePolicySum:
+			entry.value += value
+		}
+		entry.written = true
+	}
+}
+
+// forget drops the values resolved by the export, which failed, so they
+// aren't taken into account by the next exports, e.g. its retry.
+func (c *dedupCache) forget(export dedupExport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range export.keys {
+		e, ok := c.keys[key]
+		if !ok {
+			continue
+		}
+		entry := e.Value.(*dedupEntry)
+		delete(entry.pending, export.id)
+		if !entry.written && len(entry.pending) == 0 {
+			c.remove(e)
+		}
+	}
+}
+
+// resolve returns the value the export must write for the line with the
+// given key per the duplicate policy, and false if the line must be dropped.
+// The value is resolved against the values written and the ones resolved by
+// the exports not written yet, so the duplicates of concurrent exports are
+// resolved one after the other, while only the written ones are kept by
+// record.
+func (c *dedupCache) resolve(key string, value float64, export uint64, now time.Time) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+	var entry *dedupEntry
+	if e, ok := c.keys[key]; ok {
+		entry = e.Value.(*dedupEntry)
+	} else {
+		entry = &dedupEntry{key: key, recordedAt: now}
+		c.add(entry)
+	}
+	if entry.pending == nil {
+		entry.pending = make(map[uint64]float64)
+	}
+
+	switch c.policy {
+	case duplicatePolicyLast:
+		entry.pending[export] = value
+		return value, true
+	case duplicatePolicyMax:
+		// Equal values are written again so retried exports aren't dropped.
+		for _, pending := range entry.pending {
+			if value < pending {
+				return 0, false
+			}
+		}
+		if entry.written && value < entry.value {
+			return 0, false
+		}
+		entry.pending[export] = value
+		return value, true
+	case duplicatePolicySum:
+		entry.pending[export] += value
+		total := entry.value
+		for _, pending := range entry.pending {
+			total += pending
+		}
+		return total, true
+	default:
+		return 0, false
+	}
+}
+
+// add remembers the entry, forgetting the oldest one beyond maxEntries.
+func (c *dedupCache) add(entry *dedupEntry) {
+	c.keys[entry.key] = c.entries.PushBack(entry)
+	if c.entries.Len() > c.maxEntries {
+		c.remove(c.entries.Front())
+	}
+}
+
+// expire forgets the lines recorded before the window.
+func (c *dedupCache) expire(now time.Time) {
+	for e := c.entries.Front(); e != nil && now.Sub(e.Value.(*dedupEntry).recordedAt) >= c.window; e = c.entries.Front() {
+		c.remove(e)
+	}
+}
+
+func (c *dedupCache) remove(e *list.Element) {
+	delete(c.keys, e.Value.(*dedupEntry).key)
+	c.entries.Remove(e)
+}
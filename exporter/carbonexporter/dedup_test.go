@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupCache(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := newDedupCache(time.Minute, 2, duplicatePolicyDrop)
+
+	c.record(dedupExport{keys: []string{"a 1", "b 1"}}, start)
+	assert.True(t, c.seen("a 1", start.Add(time.Second)))
+	assert.True(t, c.seen("b 1", start.Add(time.Second)))
+	assert.False(t, c.seen("a 2", start.Add(time.Second)))
+
+	// Recording a key again keeps its original time.
+	c.record(dedupExport{keys: []string{"a 1"}}, start.Add(30*time.Second))
+	assert.False(t, c.seen("a 1", start.Add(time.Minute)))
+
+	// The oldest keys are forgotten once maxEntries are remembered.
+	now := start.Add(2 * time.Minute)
+	c.record(dedupExport{keys: []string{"c 1", "d 1", "e 1"}}, now)
+	assert.False(t, c.seen("c 1", now))
+	assert.True(t, c.seen("d 1", now))
+	assert.True(t, c.seen("e 1", now))
+}
+
+func TestDedupCacheResolve(t *testing.T) {
+	now := time.Unix(0, 0)
+	tests := []struct {
+		policy  string
+		written []float64
+	}{
+		{policy: duplicatePolicyLast, written: []float64{3, 1, 3, 2}},
+		// Equal values are written again.
+		{policy: duplicatePolicyMax, written: []float64{3, 3}},
+		{policy: duplicatePolicySum, written: []float64{3, 4, 7, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			c := newDedupCache(time.Minute, 10, tt.policy)
+			var written []float64
+			for _, value := range []float64{3, 1, 3, 2} {
+				if resolved, ok := c.resolve("a 1", value, c.newExport(), now); ok {
+					written = append(written, resolved)
+				}
+			}
+			assert.Equal(t, tt.written, written)
+		})
+	}
+}
+
+func TestDedupCacheResolveFailedExport(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newDedupCache(time.Minute, 10, duplicatePolicySum)
+
+	// The value of a failed export isn't added to the ones of its retry.
+	failed := dedupExport{id: c.newExport(), keys: []string{"a 1"}}
+	resolved, _ := c.resolve("a 1", 3, failed.id, now)
+	assert.Equal(t, 3.0, resolved)
+	c.forget(failed)
+	retried := dedupExport{id: c.newExport(), keys: []string{"a 1"}}
+	resolved, _ = c.resolve("a 1", 3, retried.id, now)
+	assert.Equal(t, 3.0, resolved)
+	c.record(retried, now)
+
+	// The written values are kept.
+	next := dedupExport{id: c.newExport(), keys: []string{"a 1"}}
+	resolved, _ = c.resolve("a 1", 2, next.id, now)
+	assert.Equal(t, 5.0, resolved)
+	c.forget(next)
+	resolved, _ = c.resolve("a 1", 1, c.newExport(), now)
+	assert.Equal(t, 4.0, resolved)
+}
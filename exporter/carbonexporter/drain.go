@@ -0,0 +1,148 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Supported values for Config.ShutdownDrainPolicy.
+const (
+	shutdownDrainFlush = "flush"
+	shutdownDrainDrop  = "drop"
+)
+
+// shutdownDrainer decides what happens to the data still queued once the
+// exporter starts shutting down: with the "flush" policy it is sent until the
+// drain timeout, if any, expires, with the "drop" policy it is discarded.
+type shutdownDrainer struct {
+	mtx      sync.Mutex
+	policy   string
+	timeout  time.Duration
+	clock    Clock
+	draining bool
+	deadline time.Time
+}
+
+func newShutdownDrainer(cfg *Config, clock Clock) *shutdownDrainer {
+	return &shutdownDrainer{
+		policy:  cfg.ShutdownDrainPolicy,
+		timeout: cfg.ShutdownDrainTimeout,
+		clock:   clock,
+	}
+}
+
+// start marks the beginning of the shutdown.
+func (d *shutdownDrainer) start() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.draining = true
+	if d.timeout > 0 {
+		d.deadline = d.clock.Now().Add(d.timeout)
+	}
+}
+
+// started reports whether the shutdown began.
+func (d *shutdownDrainer) started() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.draining
+}
+
+// drops reports whether data pushed now must be discarded instead of sent.
+func (d *shutdownDrainer) drops() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if !d.draining {
+		return false
+	}
+	if d.policy == shutdownDrainDrop {
+		return true
+	}
+	return !d.deadline.IsZero() && !d.clock.Now().Before(d.deadline)
+}
+
+// errShuttingDown is returned for the data consumed once the shutdown began.
+var errShuttingDown = errors.New("the exporter is shutting down")
+
+// drainingExporter starts the drainer before shutting down the wrapped
+// exporter, whose queue is drained during its Shutdown. The data consumed
+// once the shutdown began is rejected.
+type drainingExporter struct {
+	exporter.Metrics
+	drainer *shutdownDrainer
+}
+
+func (e *drainingExporter) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	if e.drainer.started() {
+		return errShuttingDown
+	}
+	return e.Metrics.ConsumeMetrics(ctx, md)
+}
+
+func (e *drainingExporter) Shutdown(ctx context.Context) error {
+	e.drainer.start()
+	return e.Metrics.Shutdown(ctx)
+}
+
+// inFlightExports tracks the exports being sent, so that the shutdown waits
+// for them before closing the connections. No export starts once it closed.
+type inFlightExports struct {
+	mtx    sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// begin reports whether an export can start, in which case end must be
+// called once it is done.
+func (e *inFlightExports) begin() bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if e.closed {
+		return false
+	}
+	e.wg.Add(1)
+	return true
+}
+
+func (e *inFlightExports) end() {
+	e.wg.Done()
+}
+
+// closeAndWait prevents new exports from starting and waits for the ones in
+// flight to be done, for at most gracePeriod unless it is 0.
+func (e *inFlightExports) closeAndWait(ctx context.Context, clock Clock, gracePeriod time.Duration) error {
+	e.mtx.Lock()
+	e.closed = true
+	e.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	var expired <-chan time.Time
+	if gracePeriod > 0 {
+		ticker := clock.NewTicker(gracePeriod)
+		defer ticker.Stop()
+		expired = ticker.C()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-expired:
+		return errors.New("the shutdown grace period expired")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -4,22 +4,88 @@
 package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.opentelemetry.io/collector/consumer/consumererror"
 	"go.opentelemetry.io/collector/exporter"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/resourcetotelemetry"
 )
 
 // newCarbonExporter returns a new Carbon exporter.
-func newCarbonExporter(cfg *Config, set exporter.CreateSettings) (exporter.Metrics, error) {
+func newCarbonExporter(cfg *Config, set exporter.CreateSettings, options ...FactoryOption) (exporter.Metrics, error) {
+	opts := factoryOptions{
+		clock:           realClock{},
+		flushErrHandler: func(error, int) {},
+		bufferPool:      newSyncBufferPool(),
+		random:          rand.Float64,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	telemetry, err := newExporterTelemetry(set.TelemetrySettings)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter, err := newFormatter(cfg, telemetry, opts.clock)
+	if err != nil {
+		return nil, err
+	}
+
+	var writer carbonWriter = newTCPConnPool(cfg, opts.endpointResolver, telemetry, opts.clock)
+	if cfg.Transport == transportUDP {
+		writer = newUDPWriter(cfg)
+	}
+
 	sender := carbonSender{
-		connPool: newTCPConnPool(cfg.Endpoint, cfg.Timeout),
+		writer:              writer,
+		formatter:           formatter,
+		flushErrHandler:     opts.flushErrHandler,
+		health:              newHealthReporter(set.TelemetrySettings.ReportComponentStatus, cfg.UnhealthyThreshold),
+		drainer:             newShutdownDrainer(cfg, opts.clock),
+		shutdownGracePeriod: cfg.ShutdownGracePeriod,
+		maintenance:         cfg.MaintenanceWindows,
+		clock:               opts.clock,
+		bufferPool:          opts.bufferPool,
+		emitShutdownMarker:  cfg.EmitShutdownMarker,
+		strictShutdown:      cfg.StrictShutdown,
+		shutdownTimeout:     cfg.Timeout,
+		logger:              set.Logger,
+		pickle:              cfg.Encoding == encodingPickle,
+		pickleMaxFrameBytes: cfg.PickleMaxFrameBytes,
+		startupJitter:       cfg.StartupJitter,
+		random:              opts.random,
+	}
+	if cfg.EmitUptime.Enabled {
+		sender.uptimeInterval = cfg.EmitUptime.Interval
+	}
+	if cfg.EmitBackpressureDrops.Enabled {
+		sender.backpressureDropsInterval = cfg.EmitBackpressureDrops.Interval
+	}
+	sender.seriesCountInterval = cfg.SeriesCountInterval
+	if cfg.RepeatLastValue.Enabled {
+		sender.repeatInterval = cfg.RepeatLastValue.Interval
 	}
 
 	exp, err := exporterhelper.NewMetricsExporter(
@@ -30,66 +96,421 @@ func newCarbonExporter(cfg *Config, set exporter.CreateSettings) (exporter.Metri
 		// We don't use exporterhelper.WithTimeout because the TCP connection does not accept writing with context.
 		exporterhelper.WithQueue(cfg.QueueConfig),
 		exporterhelper.WithRetry(cfg.RetryConfig),
+		exporterhelper.WithStart(sender.Start),
 		exporterhelper.WithShutdown(sender.Shutdown))
 	if err != nil {
 		return nil, err
 	}
 
-	return resourcetotelemetry.WrapMetricsExporter(cfg.ResourceToTelemetryConfig, exp), nil
+	if cfg.StartRetry.Enabled {
+		exp = &startRetryExporter{
+			Metrics:        exp,
+			interval:       cfg.StartRetry.Interval,
+			maxElapsedTime: cfg.StartRetry.MaxElapsedTime,
+			clock:          opts.clock,
+		}
+	}
+
+	if cfg.EmitBackpressureDrops.Enabled {
+		exp = &backpressureExporter{
+			Metrics:   exp,
+			telemetry: telemetry,
+			drops:     &sender.backpressureDrops,
+		}
+	}
+
+	return resourcetotelemetry.WrapMetricsExporter(
+		cfg.ResourceToTelemetryConfig,
+		&drainingExporter{Metrics: exp, drainer: sender.drainer},
+	), nil
 }
 
-// carbonSender is the struct tying the translation function and the TCP
+// carbonSender is the struct tying the translation function and the
 // connections into an implementations of exporterhelper.PushMetricsData so
 // the exporter can leverage the helper and get consistent observability.
 type carbonSender struct {
-	connPool *connPool
+	writer          carbonWriter
+	formatter       *formatter
+	flushErrHandler func(err error, lines int)
+	health          *healthReporter
+	drainer         *shutdownDrainer
+	// exports are the exports in flight, waited for on shutdown for up to
+	// shutdownGracePeriod.
+	exports             inFlightExports
+	shutdownGracePeriod time.Duration
+	maintenance         []MaintenanceWindow
+	clock               Clock
+	bufferPool          BufferPool
+	emitShutdownMarker  bool
+	strictShutdown      bool
+	// shutdownTimeout bounds the shutdown marker write when the shutdown
+	// context has no deadline, so that a server that stopped reading can't
+	// block the shutdown.
+	shutdownTimeout time.Duration
+	logger          *zap.Logger
+	// pickle is set when the lines are sent framed with the pickle protocol.
+	pickle              bool
+	pickleMaxFrameBytes int
+	startupJitter       time.Duration
+	random              func() float64
+	// notBefore is the time before which nothing is written, it is set on
+	// Start per startupJitter.
+	notBefore time.Time
+	// uptimeInterval is the period of the uptime lines,
+	// backpressureDropsInterval the one of the lines counting the
+	// backpressureDrops, seriesCountInterval the one of the active series
+	// lines and repeatInterval the one of the repetitions of the last values
+	// of gauges, each is 0 when disabled. They run in the background from
+	// Start until stop is closed.
+	uptimeInterval            time.Duration
+	backpressureDropsInterval time.Duration
+	backpressureDrops         atomic.Int64
+	seriesCountInterval       time.Duration
+	repeatInterval            time.Duration
+	stop                      chan struct{}
+	background                sync.WaitGroup
 }
 
-func (cs *carbonSender) pushMetricsData(_ context.Context, md pmetric.Metrics) error {
-	lines := metricDataToPlaintext(md)
+func (cs *carbonSender) pushMetricsData(ctx context.Context, md pmetric.Metrics) error {
+	if !cs.exports.begin() {
+		// The connections are closed or about to be.
+		return consumererror.NewPermanent(errShuttingDown)
+	}
+	defer cs.exports.end()
+
+	if cs.drainer.drops() {
+		cs.formatter.telemetry.recordDroppedPoints(ctx, map[string]int{dropReasonShutdown: md.DataPointCount()})
+		return nil
+	}
+	if cs.inMaintenance() {
+		cs.formatter.telemetry.recordDroppedPoints(ctx, map[string]int{dropReasonMaintenance: md.DataPointCount()})
+		return nil
+	}
 
-	if _, err := cs.connPool.Write([]byte(lines)); err != nil {
+	buf := cs.bufferPool.Get()
+	defer cs.bufferPool.Put(buf)
+	buf.Reset()
+	var frame *bytes.Buffer
+	if cs.pickle {
+		frame = cs.bufferPool.Get()
+		defer cs.bufferPool.Put(frame)
+		frame.Reset()
+	}
+
+	data, export, err := cs.format(ctx, md, buf, frame)
+	if err != nil {
+		// Retrying can't fix data that can't be formatted.
+		return consumererror.NewPermanent(err)
+	}
+	return cs.send(ctx, data, bytes.Count(buf.Bytes(), []byte("\n")), export)
+}
+
+// format converts md into the data to write: the plaintext lines written to
+// buf, framed into frame when the pickle encoding is used.
+func (cs *carbonSender) format(ctx context.Context, md pmetric.Metrics, buf, frame *bytes.Buffer) ([]byte, exportState, error) {
+	export := cs.formatter.writePlaintext(ctx, buf, md)
+	if !cs.pickle || buf.Len() == 0 {
+		return buf.Bytes(), export, nil
+	}
+	if err := appendPickleFrames(frame, buf.Bytes(), cs.pickleMaxFrameBytes); err != nil {
+		cs.formatter.forgetUnwritten(export)
+		return nil, exportState{}, err
+	}
+	return frame.Bytes(), export, nil
+}
+
+// send writes the data holding the given number of lines. Its errors are
+// retryable unless the writer reports them as permanent, e.g. for a malformed
+// endpoint.
+func (cs *carbonSender) send(ctx context.Context, data []byte, lines int, export exportState) error {
+	if err := cs.waitStartupJitter(ctx); err != nil {
+		cs.formatter.forgetUnwritten(export)
+		return err
+	}
+	n, err := cs.writer.Write(ctx, data)
+	cs.health.recordWrite(err)
+	if err != nil {
+		cs.formatter.forgetUnwritten(export)
+		cs.flushErrHandler(err, lines)
 		// Use the sum of converted and dropped since the write failed for all.
 		return err
 	}
+	cs.formatter.telemetry.recordSent(ctx, n, lines)
+	cs.formatter.recordWritten(export)
 
 	return nil
 }
 
-func (cs *carbonSender) Shutdown(context.Context) error {
-	cs.connPool.Close()
+// inMaintenance reports whether now is within one of the maintenance windows.
+func (cs *carbonSender) inMaintenance() bool {
+	if len(cs.maintenance) == 0 {
+		return false
+	}
+	now := cs.clock.Now()
+	for _, window := range cs.maintenance {
+		if window.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start loads the TLS configuration of the TCP connections, failing if the
+// certificate or key files can't be read, picks the random delay of the
+// first write and starts emitting the uptime, backpressure drops and active
+// series lines and repeating the last values.
+func (cs *carbonSender) Start(context.Context, component.Host) error {
+	if cs.startupJitter > 0 {
+		cs.notBefore = cs.clock.Now().Add(time.Duration(cs.random() * float64(cs.startupJitter)))
+	}
+	if cp, ok := cs.writer.(*connPool); ok {
+		if err := cp.loadTLSConfig(); err != nil {
+			return err
+		}
+	}
+	if cs.stop != nil {
+		// Start is retried per StartRetry when the sending queue fails to
+		// start, the background writes are already running.
+		return nil
+	}
+	cs.stop = make(chan struct{})
+	if cs.uptimeInterval > 0 {
+		startedAt := cs.clock.Now()
+		cs.runPeriodically(cs.uptimeInterval, func() (string, int) {
+			return cs.formatter.uptimeLine(cs.clock.Now().Sub(startedAt)), 1
+		})
+	}
+	if cs.backpressureDropsInterval > 0 {
+		cs.runPeriodically(cs.backpressureDropsInterval, func() (string, int) {
+			return cs.formatter.backpressureDropsLine(cs.backpressureDrops.Load()), 1
+		})
+	}
+	if cs.seriesCountInterval > 0 {
+		cs.runPeriodically(cs.seriesCountInterval, func() (string, int) {
+			return cs.formatter.activeSeriesLine(), 1
+		})
+	}
+	if cs.repeatInterval > 0 {
+		cs.runPeriodically(cs.repeatInterval, func() (string, int) {
+			return cs.formatter.repeatedLines(cs.repeatInterval)
+		})
+	}
 	return nil
 }
 
+// runPeriodically writes, in the background, the lines returned by build on
+// each tick of the interval until stop is closed. The failed writes are only
+// reported to the flushErrHandler.
+func (cs *carbonSender) runPeriodically(interval time.Duration, build func() (lines string, count int)) {
+	ticker := cs.clock.NewTicker(interval)
+	cs.background.Add(1)
+	go func() {
+		defer cs.background.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cs.stop:
+				return
+			case <-ticker.C():
+				lines, count := build()
+				if count == 0 {
+					continue
+				}
+				if err := cs.writeLines(context.Background(), lines); err != nil {
+					cs.flushErrHandler(err, count)
+				}
+			}
+		}
+	}()
+}
+
+// waitStartupJitter blocks until the delay of the first write elapsed.
+func (cs *carbonSender) waitStartupJitter(ctx context.Context) error {
+	for {
+		remaining := cs.notBefore.Sub(cs.clock.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		ticker := cs.clock.NewTicker(remaining)
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return ctx.Err()
+		case <-ticker.C():
+			ticker.Stop()
+		}
+	}
+}
+
+func (cs *carbonSender) Shutdown(ctx context.Context) error {
+	defer cs.writer.Close()
+	if cs.stop != nil {
+		close(cs.stop)
+		cs.background.Wait()
+	}
+	if err := cs.exports.closeAndWait(ctx, cs.clock, cs.shutdownGracePeriod); err != nil {
+		if cs.strictShutdown {
+			return fmt.Errorf("failed to wait for the exports in flight: %w", err)
+		}
+		cs.logger.Warn("Closing the connections with exports in flight", zap.Error(err))
+	}
+	if !cs.emitShutdownMarker {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok && cs.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cs.shutdownTimeout)
+		defer cancel()
+	}
+	// The queue is already drained, so the marker is the last line sent.
+	if err := cs.writeLines(ctx, cs.formatter.shutdownMarker()); err != nil {
+		if cs.strictShutdown {
+			return fmt.Errorf("failed to send the shutdown marker: %w", err)
+		}
+		// The data is already flushed, missing the marker isn't worth
+		// failing the shutdown.
+		cs.logger.Warn("Failed to send the shutdown marker", zap.Error(err))
+	}
+	return nil
+}
+
+// writeLines writes lines built outside of the exports, framing them when the
+// pickle encoding is used.
+func (cs *carbonSender) writeLines(ctx context.Context, lines string) error {
+	data := []byte(lines)
+	if cs.pickle {
+		var frame bytes.Buffer
+		if err := appendPickleFrames(&frame, data, cs.pickleMaxFrameBytes); err != nil {
+			return fmt.Errorf("failed to encode the line: %w", err)
+		}
+		data = frame.Bytes()
+	}
+	_, err := cs.writer.Write(ctx, data)
+	return err
+}
+
+// carbonWriter sends the serialized data to the backend.
+type carbonWriter interface {
+	Write(ctx context.Context, bytes []byte) (int, error)
+	Close()
+}
+
 // connPool is a very simple implementation of a pool of net.TCPConn instances.
 // The implementation hides the pool and exposes a Write and Close methods.
 // It leverages the prior art from SignalFx Gateway (see
 // https://github.com/signalfx/gateway/blob/master/protocol/carbon/conn_pool.go
 // but not its implementation).
 //
-// It keeps an unbounded "stack" of TCPConn instances always "popping" the most
-// recently returned to the pool. There is no accounting to terminating old
-// unused connections as that was the case on the prior art mentioned above.
+// It keeps a "stack" of TCPConn instances always "popping" the most recently
+// returned to the pool. There is no accounting to terminating old unused
+// connections as that was the case on the prior art mentioned above. The
+// connections popped from the stack are checked to not have been closed by
+// the server, otherwise they are discarded.
+//
+// When MaxConnections is set at most that many connections are in use or in the
+// stack at a time, writes wait for one of them to be available. Otherwise the
+// stack is unbounded.
+//
+// If an endpointResolver is set it is called each time a new connection is
+// created to pick the endpoint to dial, otherwise the static endpoint is used.
+//
+// If a readinessProbeLine is set it is sent on each new connection, which is
+// only used once the server replies to it.
+//
+// Nagle's algorithm is disabled on new connections when noDelay is set, their
+// keep-alives are set per keepAlivePeriod and their SO_LINGER per linger,
+// unless nil.
+//
+// New connections are secured with TLS when tlsSetting enables it, the
+// handshake completing within the configured timeout.
+//
+// The failures to create or write to a connection are reported to telemetry,
+// as are the new connections replacing the ones closed because of a failure
+// or by the server.
+//
+// When reconnect is enabled, a connection broken while writing a batch is
+// replaced, after a backoff, by a new one the whole batch is written to again.
 type connPool struct {
-	mtx      sync.Mutex
-	conns    []*net.TCPConn
-	endpoint string
-	timeout  time.Duration
+	mtx   sync.Mutex
+	conns []net.Conn
+	// slots bounds the concurrent writes to maxConns, and so the connections
+	// as new ones are only created when the stack is empty. It is nil when
+	// the number of connections is unbounded.
+	slots              chan struct{}
+	endpoint           string
+	endpointResolver   func(context.Context) (string, error)
+	timeout            time.Duration
+	writeTimeout       time.Duration
+	reconnect          ReconnectConfig
+	readinessProbeLine string
+	noDelay            bool
+	keepAlivePeriod    time.Duration
+	linger             *time.Duration
+	dialer             *net.Dialer
+	tlsSetting         *configtls.TLSClientSetting
+	// tlsConfig is loaded by loadTLSConfig, it is nil when TLS is disabled.
+	tlsConfig *tls.Config
+	// unixSocket is set when the endpoint is the path of a Unix socket.
+	unixSocket bool
+	telemetry  *exporterTelemetry
+	// clock times the waits between the reconnection attempts.
+	clock Clock
+	// lost is the number of connections closed and not yet replaced by a new
+	// one, it is used to count the reconnects.
+	lost int
 }
 
 func newTCPConnPool(
-	endpoint string,
-	timeout time.Duration,
+	cfg *Config,
+	endpointResolver func(context.Context) (string, error),
+	telemetry *exporterTelemetry,
+	clock Clock,
 ) *connPool {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.DualStack {
+		// A negative delay disables the concurrent IPv4 and IPv6 dials,
+		// addresses are tried one after the other.
+		dialer.FallbackDelay = -1
+	}
+	var slots chan struct{}
+	if cfg.MaxConnections > 0 {
+		slots = make(chan struct{}, cfg.MaxConnections)
+	}
 	return &connPool{
-		endpoint: endpoint,
-		timeout:  timeout,
+		slots:              slots,
+		endpoint:           cfg.Endpoint,
+		endpointResolver:   endpointResolver,
+		timeout:            cfg.Timeout,
+		writeTimeout:       cfg.WriteTimeout,
+		reconnect:          cfg.Reconnect,
+		readinessProbeLine: cfg.ReadinessProbeLine,
+		noDelay:            cfg.NoDelay,
+		keepAlivePeriod:    cfg.KeepAlivePeriod,
+		linger:             cfg.Linger,
+		dialer:             dialer,
+		tlsSetting:         cfg.TLSSetting,
+		unixSocket:         cfg.Transport == transportUnix,
+		telemetry:          telemetry,
+		clock:              clock,
 	}
 }
 
-func (cp *connPool) Write(bytes []byte) (int, error) {
-	var conn *net.TCPConn
+func (cp *connPool) Write(ctx context.Context, bytes []byte) (int, error) {
+	var conn net.Conn
 	var err error
+	// errRecorded is set once the failures are recorded as they happen, so
+	// the deferred function doesn't record the last one again.
+	var errRecorded bool
+
+	start := time.Now()
+	if cp.slots != nil {
+		select {
+		case cp.slots <- struct{}{}:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		defer func() { <-cp.slots }()
+	}
 
 	// The deferred function below is what puts back connections on the pool.
 	defer func() {
@@ -97,21 +518,19 @@ func (cp *connPool) Write(bytes []byte) (int, error) {
 			cp.mtx.Lock()
 			cp.conns = append(cp.conns, conn)
 			cp.mtx.Unlock()
-		} else if conn != nil {
-			conn.Close()
+			return
+		}
+		if !errRecorded {
+			cp.telemetry.recordConnectionError(ctx)
+		}
+		if conn != nil {
+			cp.discard(conn)
 		}
 	}()
 
-	start := time.Now()
-	cp.mtx.Lock()
-	lastIdx := len(cp.conns) - 1
-	if lastIdx >= 0 {
-		conn = cp.conns[lastIdx]
-		cp.conns = cp.conns[0:lastIdx]
-	}
-	cp.mtx.Unlock()
+	conn = cp.checkout()
 	if conn == nil {
-		if conn, err = cp.createTCPConn(); err != nil {
+		if conn, err = cp.newConn(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -135,13 +554,133 @@ func (cp *connPool) Write(bytes []byte) (int, error) {
 	// needed in some scenarios the workaround should be validated on other
 	// platforms and offered as a configuration setting.
 
-	if err = conn.SetWriteDeadline(start.Add(cp.timeout)); err != nil {
+	var n int
+	n, err = cp.write(conn, start, bytes)
+	if err == nil || !cp.reconnect.Enabled || isTimeout(err) {
+		return n, err
+	}
+
+	// The connection broke mid-batch. The data it accepted may not have
+	// reached Carbon, so rather than resuming after the lines already
+	// written, the whole batch is written again on a new connection, Carbon
+	// overwrites the repeated points with the same values.
+	cp.telemetry.recordConnectionError(ctx)
+	errRecorded = true
+	interval := cp.reconnect.InitialInterval
+	for attempt := 0; attempt < cp.reconnect.MaxAttempts; attempt++ {
+		if conn != nil {
+			cp.discard(conn)
+			conn = nil
+		}
+
+		ticker := cp.clock.NewTicker(interval)
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			err = ctx.Err()
+			return 0, err
+		case <-ticker.C():
+		}
+		ticker.Stop()
+		interval = time.Duration(math.Min(float64(interval)*cp.reconnect.Multiplier, float64(cp.reconnect.MaxInterval)))
+
+		if conn, err = cp.newConn(ctx); err == nil {
+			if n, err = cp.write(conn, time.Now(), bytes); err == nil {
+				return n, nil
+			}
+		}
+		cp.telemetry.recordConnectionError(ctx)
+		if isTimeout(err) {
+			return n, err
+		}
+	}
+	return 0, err
+}
+
+// write writes data to conn within the write timeout if set, within the
+// timeout since start otherwise.
+func (cp *connPool) write(conn net.Conn, start time.Time, data []byte) (int, error) {
+	deadline := start.Add(cp.timeout)
+	if cp.writeTimeout > 0 {
+		deadline = time.Now().Add(cp.writeTimeout)
+	}
+	if err := conn.SetWriteDeadline(deadline); err != nil {
 		return 0, err
 	}
+	return conn.Write(data)
+}
 
-	var n int
-	n, err = conn.Write(bytes)
-	return n, err
+// newConn creates a new connection, reporting it as a reconnect when it
+// replaces a connection closed because of a failure or by the server.
+func (cp *connPool) newConn(ctx context.Context) (net.Conn, error) {
+	conn, err := cp.createConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cp.mtx.Lock()
+	reconnect := cp.lost > 0
+	if reconnect {
+		cp.lost--
+	}
+	cp.mtx.Unlock()
+	if reconnect {
+		cp.telemetry.recordReconnect(ctx)
+	}
+	return conn, nil
+}
+
+// discard closes a connection that failed or was closed by the server, it is
+// counted as lost until a new connection replaces it.
+func (cp *connPool) discard(conn net.Conn) {
+	conn.Close()
+	cp.mtx.Lock()
+	cp.lost++
+	cp.mtx.Unlock()
+}
+
+// isTimeout reports whether err is a timeout, which reconnecting can't fix as
+// the time allowed is spent.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// checkout pops the most recently returned connection still open from the
+// stack, closing the ones found closed by the server. It returns nil if there
+// are none.
+func (cp *connPool) checkout() net.Conn {
+	for {
+		cp.mtx.Lock()
+		lastIdx := len(cp.conns) - 1
+		if lastIdx < 0 {
+			cp.mtx.Unlock()
+			return nil
+		}
+		conn := cp.conns[lastIdx]
+		cp.conns = cp.conns[0:lastIdx]
+		cp.mtx.Unlock()
+
+		if isOpen(conn) {
+			return conn
+		}
+		cp.discard(conn)
+	}
+}
+
+// isOpen reports whether conn wasn't closed by the server.
+func isOpen(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	return !peerClosed(rawConn)
 }
 
 func (cp *connPool) Close() {
@@ -154,10 +693,191 @@ func (cp *connPool) Close() {
 	cp.conns = nil
 }
 
-func (cp *connPool) createTCPConn() (*net.TCPConn, error) {
-	c, err := net.DialTimeout("tcp", cp.endpoint, cp.timeout)
+// loadTLSConfig loads the TLS configuration used by the new connections.
+func (cp *connPool) loadTLSConfig() error {
+	if cp.tlsSetting == nil {
+		return nil
+	}
+	tlsConfig, err := cp.tlsSetting.LoadTLSConfig()
 	if err != nil {
+		return err
+	}
+
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	cp.tlsConfig = tlsConfig
+	return nil
+}
+
+// createConn creates a new connection, secured with TLS if enabled, and
+// checks its readiness if a readiness probe line is set.
+func (cp *connPool) createConn(ctx context.Context) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if cp.unixSocket {
+		if conn, err = cp.dialer.Dial(transportUnix, cp.endpoint); err != nil {
+			return nil, err
+		}
+	} else {
+		var tcpConn *net.TCPConn
+		var endpoint string
+		if tcpConn, endpoint, err = cp.createTCPConn(ctx); err != nil {
+			return nil, err
+		}
+		conn = tcpConn
+		cp.mtx.Lock()
+		tlsConfig := cp.tlsConfig
+		cp.mtx.Unlock()
+		if tlsConfig != nil {
+			if conn, err = cp.handshake(ctx, tcpConn, endpoint, tlsConfig); err != nil {
+				tcpConn.Close()
+				return nil, fmt.Errorf("TLS handshake failed: %w", err)
+			}
+		}
+	}
+
+	if cp.readinessProbeLine != "" {
+		if err = cp.probeReadiness(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("readiness probe failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// handshake secures conn, dialed to endpoint, with TLS. The handshake must
+// complete within the configured timeout.
+func (cp *connPool) handshake(ctx context.Context, conn *net.TCPConn, endpoint string, tlsConfig *tls.Config) (*tls.Conn, error) {
+	if tlsConfig.ServerName == "" {
+		// As tls.Dial does, verify the certificate against the dialed host.
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(cp.timeout)); err != nil {
 		return nil, err
 	}
-	return c.(*net.TCPConn), err
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	// Clear the deadline, writes set their own.
+	return tlsConn, conn.SetDeadline(time.Time{})
+}
+
+// createTCPConn dials a new TCP connection, returning it together with the
+// endpoint it was dialed to.
+func (cp *connPool) createTCPConn(ctx context.Context) (*net.TCPConn, string, error) {
+	endpoint := cp.endpoint
+	if cp.endpointResolver != nil {
+		var err error
+		if endpoint, err = cp.endpointResolver(ctx); err != nil {
+			return nil, "", fmt.Errorf("failed to resolve the endpoint: %w", err)
+		}
+	}
+
+	c, err := cp.dialer.Dial("tcp", endpoint)
+	if err != nil {
+		if isInvalidAddressError(err) {
+			// Retrying cannot fix a malformed endpoint, let the retry and queue
+			// machinery drop the data instead of retrying it.
+			return nil, "", consumererror.NewPermanent(err)
+		}
+		return nil, "", classifyDNSError(err)
+	}
+
+	conn := c.(*net.TCPConn)
+	if err = cp.setOptions(conn); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return conn, endpoint, nil
+}
+
+// tcpOptions are the options of a *net.TCPConn set by setOptions.
+type tcpOptions interface {
+	SetNoDelay(noDelay bool) error
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+	SetLinger(sec int) error
+}
+
+// setOptions sets the options of a newly dialed connection. The keep-alives
+// enabled by the dialer, with the Go default period, are kept as they are
+// when keepAlivePeriod is 0.
+func (cp *connPool) setOptions(conn tcpOptions) error {
+	if err := conn.SetNoDelay(cp.noDelay); err != nil {
+		return err
+	}
+	switch {
+	case cp.keepAlivePeriod < 0:
+		if err := conn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	case cp.keepAlivePeriod > 0:
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := conn.SetKeepAlivePeriod(cp.keepAlivePeriod); err != nil {
+			return err
+		}
+	}
+	if cp.linger != nil {
+		return conn.SetLinger(int(*cp.linger / time.Second))
+	}
+	return nil
+}
+
+// maxProbeResponseSize bounds how much is read while waiting for the reply
+// to the readiness probe.
+const maxProbeResponseSize = 1024
+
+// probeReadiness sends the readiness probe line and waits, up to the
+// configured timeout, for the server to reply with a non-empty line.
+func (cp *connPool) probeReadiness(conn net.Conn) error {
+	if err := conn.SetDeadline(time.Now().Add(cp.timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte(cp.readinessProbeLine + "\n")); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(io.LimitReader(conn, maxProbeResponseSize)).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) == "" {
+		return errors.New("server sent an empty reply")
+	}
+
+	// Clear the deadline, writes set their own.
+	return conn.SetDeadline(time.Time{})
+}
+
+// classifyDNSError describes the failures to resolve the host of the
+// endpoint, which are permanent when the host doesn't exist, e.g. NXDOMAIN,
+// and transient otherwise, e.g. on a DNS timeout. Other errors are returned
+// unchanged.
+func classifyDNSError(err error) error {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return err
+	}
+	err = fmt.Errorf("failed to resolve the host %q of the endpoint: %w", dnsErr.Name, err)
+	if dnsErr.IsNotFound && !dnsErr.IsTemporary {
+		return consumererror.NewPermanent(err)
+	}
+	return err
+}
+
+// isInvalidAddressError reports if the dial error was caused by an endpoint
+// that cannot be dialed at all, e.g. one missing the port.
+func isInvalidAddressError(err error) bool {
+	var addrErr *net.AddrError
+	var unknownNetworkErr net.UnknownNetworkError
+	return errors.As(err, &addrErr) || errors.As(err, &unknownNetworkErr)
 }
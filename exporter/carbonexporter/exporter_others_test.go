@@ -0,0 +1,163 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package carbonexporter
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/exporter/exportertest"
+	"golang.org/x/sys/unix"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/common/testutil"
+)
+
+func TestNoDelay(t *testing.T) {
+	for _, noDelay := range []bool{true, false} {
+		t.Run(strconv.FormatBool(noDelay), func(t *testing.T) {
+			addr := testutil.GetAvailableLocalAddress(t)
+			cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+			cs.start(t, 1)
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = addr
+			cfg.NoDelay = noDelay
+
+			cp := newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{})
+			conn, _, err := cp.createTCPConn(context.Background())
+			require.NoError(t, err)
+			rawConn, err := conn.SyscallConn()
+			require.NoError(t, err)
+			var opt int
+			var optErr error
+			require.NoError(t, rawConn.Control(func(fd uintptr) {
+				opt, optErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+			}))
+			require.NoError(t, optErr)
+			assert.Equal(t, noDelay, opt != 0)
+			require.NoError(t, conn.Close())
+
+			// The data is delivered either way.
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+			require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+			require.NoError(t, exp.Shutdown(context.Background()))
+			cs.shutdownAndVerify(t)
+		})
+	}
+}
+
+func TestLinger(t *testing.T) {
+	tests := []struct {
+		name   string
+		linger *time.Duration
+		want   unix.Linger
+	}{
+		{name: "unset", want: unix.Linger{}},
+		{name: "reset", linger: durationPtr(0), want: unix.Linger{Onoff: 1}},
+		{name: "wait", linger: durationPtr(5 * time.Second), want: unix.Linger{Onoff: 1, Linger: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = ln.Addr().String()
+			cfg.Linger = tt.linger
+
+			cp := newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{})
+			conn, _, err := cp.createTCPConn(context.Background())
+			require.NoError(t, err)
+			rawConn, err := conn.SyscallConn()
+			require.NoError(t, err)
+			var linger *unix.Linger
+			var optErr error
+			require.NoError(t, rawConn.Control(func(fd uintptr) {
+				linger, optErr = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+			}))
+			require.NoError(t, optErr)
+			assert.Equal(t, tt.want.Onoff != 0, linger.Onoff != 0)
+			assert.Equal(t, tt.want.Linger, linger.Linger)
+			require.NoError(t, conn.Close())
+			if tt.linger != nil && *tt.linger == 0 {
+				// The data not yet sent is discarded on close by design.
+				return
+			}
+
+			// The data written before closing is delivered.
+			addr := testutil.GetAvailableLocalAddress(t)
+			cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+			cs.start(t, 1)
+			cfg.Endpoint = addr
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+			require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+			require.NoError(t, exp.Shutdown(context.Background()))
+			cs.shutdownAndVerify(t)
+		})
+	}
+}
+
+func TestConnPoolDiscardsClosedConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	lines := make(chan string, 2)
+	closed := make(chan struct{})
+	go func() {
+		// The first connection is closed by the server once a line is read.
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			lines <- line
+			conn.Close()
+			if i == 0 {
+				close(closed)
+			}
+		}
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	set, reader := newTestTelemetrySettings()
+	telemetry, err := newExporterTelemetry(set)
+	require.NoError(t, err)
+	cp := newTCPConnPool(cfg, nil, telemetry, realClock{})
+	defer cp.Close()
+	_, err = cp.Write(context.Background(), []byte("a 1 0\n"))
+	require.NoError(t, err)
+	<-closed
+
+	// Wait for the connection in the pool to see the server closing it, it
+	// is then discarded instead of losing the data written to it.
+	cp.mtx.Lock()
+	require.Len(t, cp.conns, 1)
+	pooled := cp.conns[0]
+	cp.mtx.Unlock()
+	assert.Eventually(t, func() bool { return !isOpen(pooled) }, 5*time.Second, 10*time.Millisecond)
+	_, err = cp.Write(context.Background(), []byte("b 2 0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a 1 0\n", <-lines)
+	assert.Equal(t, "b 2 0\n", <-lines)
+	assert.Equal(t, map[string]int64{"": 1}, collectSums(t, reader, "exporter_carbon_reconnects", ""))
+	assert.Empty(t, collectSums(t, reader, "exporter_carbon_connection_errors", ""))
+}
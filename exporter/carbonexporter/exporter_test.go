@@ -5,12 +5,27 @@ package carbonexporter
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -18,13 +33,19 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/component/componenttest"
 	"go.opentelemetry.io/collector/config/confignet"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.opentelemetry.io/collector/consumer/consumererror"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 	"go.opentelemetry.io/collector/exporter/exportertest"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	conventions "go.opentelemetry.io/collector/semconv/v1.9.0"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/common/testutil"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/resourcetotelemetry"
@@ -50,25 +71,1811 @@ func TestConsumeMetricsNoServer(t *testing.T) {
 	require.NoError(t, exp.Shutdown(context.Background()))
 }
 
+func TestFlushErrorHandler(t *testing.T) {
+	var (
+		handledErr   error
+		handledLines int
+	)
+	exp, err := newCarbonExporter(
+		&Config{
+			TCPAddr:         confignet.TCPAddr{Endpoint: testutil.GetAvailableLocalAddress(t)},
+			TimeoutSettings: exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
+		},
+		exportertest.NewNopCreateSettings(),
+		WithFlushErrorHandler(func(err error, lines int) {
+			handledErr = err
+			handledLines = lines
+		}))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// No server is listening, so the write fails.
+	err = exp.ConsumeMetrics(context.Background(), generateMetricsBatch(3))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, handledErr)
+	assert.Equal(t, 3, handledLines)
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestConsumeMetricsUnhealthyThreshold(t *testing.T) {
+	var statuses []component.Status
+	set := exportertest.NewNopCreateSettings()
+	set.TelemetrySettings.ReportComponentStatus = func(ev *component.StatusEvent) error {
+		statuses = append(statuses, ev.Status())
+		return nil
+	}
+	exp, err := newCarbonExporter(
+		&Config{
+			TCPAddr:            confignet.TCPAddr{Endpoint: testutil.GetAvailableLocalAddress(t)},
+			TimeoutSettings:    exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
+			UnhealthyThreshold: 2,
+		},
+		set)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// No server is listening, so every write fails.
+	require.Error(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	assert.Empty(t, statuses)
+	require.Error(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	assert.Equal(t, []component.Status{component.StatusRecoverableError}, statuses)
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestShutdownDrainPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		timeout time.Duration
+		// elapsed is the time passed between the start of the shutdown and
+		// the queued data being sent.
+		elapsed       time.Duration
+		wantDelivered int
+	}{
+		{
+			name:          "flush",
+			policy:        shutdownDrainFlush,
+			wantDelivered: 4,
+		},
+		{
+			name:          "flush_within_timeout",
+			policy:        shutdownDrainFlush,
+			timeout:       time.Minute,
+			elapsed:       30 * time.Second,
+			wantDelivered: 4,
+		},
+		{
+			name:          "flush_timeout_expired",
+			policy:        shutdownDrainFlush,
+			timeout:       time.Minute,
+			elapsed:       time.Minute,
+			wantDelivered: 1,
+		},
+		{
+			name:          "drop",
+			policy:        shutdownDrainDrop,
+			timeout:       time.Minute,
+			wantDelivered: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := testutil.GetAvailableLocalAddress(t)
+			cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+			cs.start(t, tt.wantDelivered)
+
+			// The resolver holds the first export so the next ones stay in
+			// the queue until the shutdown starts.
+			resolving := make(chan struct{})
+			release := make(chan struct{})
+			var once sync.Once
+			resolver := func(context.Context) (string, error) {
+				once.Do(func() {
+					close(resolving)
+					<-release
+				})
+				return addr, nil
+			}
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = addr
+			cfg.QueueConfig.NumConsumers = 1
+			cfg.RetryConfig.Enabled = false
+			cfg.ShutdownDrainPolicy = tt.policy
+			cfg.ShutdownDrainTimeout = tt.timeout
+			clock := newFakeClock(time.Now())
+			set := exportertest.NewNopCreateSettings()
+			telemetry, reader := newTestTelemetrySettings()
+			set.TelemetrySettings = telemetry
+			exp, err := newCarbonExporter(cfg, set, WithEndpointResolver(resolver), WithClock(clock))
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+			for i := 0; i < 4; i++ {
+				require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+			}
+			<-resolving
+
+			shutdownErr := make(chan error)
+			go func() {
+				shutdownErr <- exp.Shutdown(context.Background())
+			}()
+			drainer := exp.(*drainingExporter).drainer
+			require.Eventually(t, drainer.started, 5*time.Second, 10*time.Millisecond)
+			clock.Advance(tt.elapsed)
+			close(release)
+			require.NoError(t, <-shutdownErr)
+
+			cs.shutdownAndVerify(t)
+			dropped := collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey)
+			assert.EqualValues(t, 4-tt.wantDelivered, dropped[dropReasonShutdown])
+		})
+	}
+}
+
+func TestShutdownWaitsForExportsInFlight(t *testing.T) {
+	t.Run("queued", func(t *testing.T) {
+		addr := testutil.GetAvailableLocalAddress(t)
+		cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+		cs.start(t, 1)
+
+		cfg := createDefaultConfig().(*Config)
+		cfg.Endpoint = addr
+		exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+		require.NoError(t, err)
+		require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+		require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+		require.NoError(t, exp.Shutdown(context.Background()))
+		cs.shutdownAndVerify(t)
+	})
+
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		// expire advances the clock past the grace period before releasing
+		// the export in flight.
+		expire  bool
+		wantErr bool
+	}{
+		{name: "wait"},
+		{name: "within_grace_period", gracePeriod: time.Minute},
+		{name: "grace_period_expired", gracePeriod: time.Minute, expire: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := testutil.GetAvailableLocalAddress(t)
+			cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+			cs.start(t, 1)
+
+			// The resolver holds the export until the shutdown started.
+			resolving := make(chan struct{})
+			release := make(chan struct{})
+			var once sync.Once
+			resolver := func(context.Context) (string, error) {
+				once.Do(func() {
+					close(resolving)
+					<-release
+				})
+				return addr, nil
+			}
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = addr
+			cfg.QueueConfig.Enabled = false
+			cfg.RetryConfig.Enabled = false
+			cfg.ShutdownGracePeriod = tt.gracePeriod
+			cfg.StrictShutdown = true
+			clock := newFakeClock(time.Now())
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithEndpointResolver(resolver), WithClock(clock))
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+			consumeErr := make(chan error)
+			go func() {
+				consumeErr <- exp.ConsumeMetrics(context.Background(), generateSmallBatch())
+			}()
+			<-resolving
+
+			shutdownErr := make(chan error)
+			go func() {
+				shutdownErr <- exp.Shutdown(context.Background())
+			}()
+			drainer := exp.(*drainingExporter).drainer
+			require.Eventually(t, drainer.started, 5*time.Second, 10*time.Millisecond)
+			assert.ErrorIs(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()), errShuttingDown)
+			if tt.expire {
+				require.Eventually(t, func() bool { return clock.tickerCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+				clock.Advance(tt.gracePeriod)
+				assert.Error(t, <-shutdownErr)
+				close(release)
+				<-consumeErr
+				return
+			}
+			select {
+			case err := <-shutdownErr:
+				t.Fatalf("shutdown returned before the export in flight was done: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+			require.NoError(t, <-consumeErr)
+			require.NoError(t, <-shutdownErr)
+			cs.shutdownAndVerify(t)
+		})
+	}
+}
+
+func TestStartRetry(t *testing.T) {
+	tests := []struct {
+		name           string
+		failures       int64
+		maxElapsedTime time.Duration
+		wantErr        bool
+	}{
+		{
+			name:           "storage_becomes_available",
+			failures:       3,
+			maxElapsedTime: 10 * time.Second,
+		},
+		{
+			name:           "storage_never_available",
+			failures:       math.MaxInt64,
+			maxElapsedTime: 100 * time.Millisecond,
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storageID := component.NewID("file_storage")
+			ext := &flakyStorageExtension{failures: tt.failures}
+			host := &storageHost{
+				Host:       componenttest.NewNopHost(),
+				extensions: map[component.ID]component.Component{storageID: ext},
+			}
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = testutil.GetAvailableLocalAddress(t)
+			cfg.QueueConfig.StorageID = &storageID
+			cfg.StartRetry.Enabled = true
+			cfg.StartRetry.Interval = 10 * time.Millisecond
+			cfg.StartRetry.MaxElapsedTime = tt.maxElapsedTime
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+
+			err = exp.Start(context.Background(), host)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.failures+1, ext.calls.Load())
+			require.NoError(t, exp.Shutdown(context.Background()))
+		})
+	}
+}
+
+// storageHost is a component.Host exposing the given extensions.
+type storageHost struct {
+	component.Host
+	extensions map[component.ID]component.Component
+}
+
+func (h *storageHost) GetExtensions() map[component.ID]component.Component {
+	return h.extensions
+}
+
+// flakyStorageExtension is a storage.Extension failing to provide a client
+// the first failures times.
+type flakyStorageExtension struct {
+	component.StartFunc
+	component.ShutdownFunc
+	failures int64
+	calls    atomic.Int64
+}
+
+func (e *flakyStorageExtension) GetClient(context.Context, component.Kind, component.ID, string) (storage.Client, error) {
+	if e.calls.Add(1) <= e.failures {
+		return nil, errors.New("storage is not available yet")
+	}
+	return storage.NewNopClient(), nil
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	cs.start(t, 1)
+
+	windowStart := time.Date(2023, 12, 1, 22, 0, 0, 0, time.UTC)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.RetryConfig.Enabled = false
+	cfg.MaintenanceWindows = []MaintenanceWindow{{Start: windowStart, End: windowStart.Add(time.Hour)}}
+	clock := newFakeClock(windowStart)
+	set := exportertest.NewNopCreateSettings()
+	telemetry, reader := newTestTelemetrySettings()
+	set.TelemetrySettings = telemetry
+	exp, err := newCarbonExporter(cfg, set, WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Exports within the window are dropped and counted.
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateMetricsBatch(2)))
+	dropped := collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey)
+	assert.EqualValues(t, 2, dropped[dropReasonMaintenance])
+
+	// Exports after the window flow to the server.
+	clock.Advance(time.Hour)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+}
+
+func TestStartupJitter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	var dials atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			_, _ = io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	cfg.QueueConfig.Enabled = false
+	cfg.StartupJitter = 10 * time.Second
+	clock := newFakeClock(time.Unix(0, 0))
+	random := func(opts *factoryOptions) {
+		opts.random = func() float64 { return 0.5 }
+	}
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock), random)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- exp.ConsumeMetrics(context.Background(), generateSmallBatch())
+	}()
+
+	// The first dial waits for the drawn half of the jitter.
+	clock.Advance(4 * time.Second)
+	assert.Never(t, func() bool { return dials.Load() > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+	clock.Advance(time.Second)
+	require.NoError(t, <-consumed)
+	assert.Eventually(t, func() bool { return dials.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Later writes are not delayed.
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	const producers = 10
+	cs.start(t, producers)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.MaxConnections = 2
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for i := 0; i < producers; i++ {
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+	assert.LessOrEqual(t, cs.accepted.Load(), int64(cfg.MaxConnections))
+}
+
+func TestBufferPool(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	cs.start(t, 2)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	pool := &countingBufferPool{}
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithBufferPool(pool))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	}
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+
+	assert.EqualValues(t, 2, pool.gets.Load())
+	assert.EqualValues(t, 2, pool.puts.Load())
+}
+
+// countingBufferPool is a BufferPool counting the buffers got and put back.
+type countingBufferPool struct {
+	gets atomic.Int64
+	puts atomic.Int64
+}
+
+func (p *countingBufferPool) Get() *bytes.Buffer {
+	p.gets.Add(1)
+	return new(bytes.Buffer)
+}
+
+func (p *countingBufferPool) Put(*bytes.Buffer) {
+	p.puts.Add(1)
+}
+
 func TestConsumeMetricsWithResourceToTelemetry(t *testing.T) {
 	addr := testutil.GetAvailableLocalAddress(t)
-	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1;service.name=test_carbon 0")
-	// Each metric point will generate one Carbon line, set up the wait
-	// for all of them.
-	cs.start(t, 1)
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1;service.name=test_carbon 0")
+	// Each metric point will generate one Carbon line, set up the wait
+	// for all of them.
+	cs.start(t, 1)
+
+	exp, err := newCarbonExporter(
+		&Config{
+			TCPAddr:                   confignet.TCPAddr{Endpoint: addr},
+			TimeoutSettings:           exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
+			ResourceToTelemetryConfig: resourcetotelemetry.Settings{Enabled: true},
+		},
+		exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	assert.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+}
+
+func TestEndpointResolver(t *testing.T) {
+	// Larger than the socket buffers, so the write fails once the first
+	// server closes the connection.
+	var batch bytes.Buffer
+	lineCount := 0
+	for ; batch.Len() < 32<<20; lineCount++ {
+		batch.WriteString("test_" + strconv.Itoa(lineCount) + " 1 0\n")
+	}
+
+	// The first server breaks the connection after the first lines, the
+	// second one reads the batch written again after the reconnect.
+	received := make(chan int, 1)
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		listeners[i] = ln
+		go func(i int) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			if i == 0 {
+				for j := 0; j < 100; j++ {
+					_, _ = reader.ReadString('\n')
+				}
+				return
+			}
+			lines := 0
+			for {
+				if _, err := reader.ReadString('\n'); err != nil {
+					break
+				}
+				lines++
+			}
+			received <- lines
+		}(i)
+	}
+
+	var calls atomic.Int64
+	resolver := func(context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			return listeners[0].Addr().String(), nil
+		}
+		return listeners[1].Addr().String(), nil
+	}
+	cfg := createDefaultConfig().(*Config)
+	// Nothing listens on the configured endpoint, the servers are only
+	// reachable through the resolver.
+	cfg.Endpoint = testutil.GetAvailableLocalAddress(t)
+	cfg.Reconnect = ReconnectConfig{
+		Enabled:         true,
+		MaxAttempts:     3,
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     20 * time.Millisecond,
+		Multiplier:      2,
+	}
+	cp := newTCPConnPool(cfg, resolver, newNopTelemetry(t), realClock{})
+
+	n, err := cp.Write(context.Background(), batch.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, batch.Len(), n)
+	cp.Close()
+
+	// The reconnect asked the resolver for the endpoint again.
+	assert.Equal(t, lineCount, <-received)
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestDualStack(t *testing.T) {
+	// Listen only on IPv4 while "localhost" usually resolves to both the
+	// IPv4 and IPv6 loopback addresses.
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = net.JoinHostPort("localhost", port)
+	require.True(t, cfg.DualStack)
+	cp := newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{})
+
+	start := time.Now()
+	conn, _, err := cp.createTCPConn(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.Less(t, time.Since(start), cfg.Timeout)
+}
+
+func TestSetOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{
+			// The keep-alives enabled by the dialer are kept as they are.
+			name:   "default",
+			modify: func(*Config) {},
+			want:   []string{"SetNoDelay(true)"},
+		},
+		{
+			name:   "no_delay_disabled",
+			modify: func(cfg *Config) { cfg.NoDelay = false },
+			want:   []string{"SetNoDelay(false)"},
+		},
+		{
+			name:   "keep_alive_period",
+			modify: func(cfg *Config) { cfg.KeepAlivePeriod = 30 * time.Second },
+			want:   []string{"SetNoDelay(true)", "SetKeepAlive(true)", "SetKeepAlivePeriod(30s)"},
+		},
+		{
+			name:   "keep_alive_disabled",
+			modify: func(cfg *Config) { cfg.KeepAlivePeriod = -1 },
+			want:   []string{"SetNoDelay(true)", "SetKeepAlive(false)"},
+		},
+		{
+			name:   "linger",
+			modify: func(cfg *Config) { cfg.Linger = durationPtr(5 * time.Second) },
+			want:   []string{"SetNoDelay(true)", "SetLinger(5)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.modify(cfg)
+			conn := &recordingTCPConn{}
+			require.NoError(t, newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{}).setOptions(conn))
+			assert.Equal(t, tt.want, conn.calls)
+		})
+	}
+
+	// A failure is returned right away.
+	conn := &recordingTCPConn{err: errors.New("unsupported")}
+	assert.Error(t, newTCPConnPool(createDefaultConfig().(*Config), nil, newNopTelemetry(t), realClock{}).setOptions(conn))
+	assert.Equal(t, []string{"SetNoDelay(true)"}, conn.calls)
+}
+
+// recordingTCPConn records the options set on it, failing with err if set.
+type recordingTCPConn struct {
+	calls []string
+	err   error
+}
+
+func (c *recordingTCPConn) SetNoDelay(noDelay bool) error {
+	c.calls = append(c.calls, fmt.Sprintf("SetNoDelay(%t)", noDelay))
+	return c.err
+}
+
+func (c *recordingTCPConn) SetKeepAlive(keepalive bool) error {
+	c.calls = append(c.calls, fmt.Sprintf("SetKeepAlive(%t)", keepalive))
+	return c.err
+}
+
+func (c *recordingTCPConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.calls = append(c.calls, fmt.Sprintf("SetKeepAlivePeriod(%s)", d))
+	return c.err
+}
+
+func (c *recordingTCPConn) SetLinger(sec int) error {
+	c.calls = append(c.calls, fmt.Sprintf("SetLinger(%d)", sec))
+	return c.err
+}
+
+func TestEndpointResolverError(t *testing.T) {
+	exp, err := newCarbonExporter(
+		&Config{
+			TCPAddr:         confignet.TCPAddr{Endpoint: testutil.GetAvailableLocalAddress(t)},
+			TimeoutSettings: exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
+		},
+		exportertest.NewNopCreateSettings(),
+		WithEndpointResolver(func(context.Context) (string, error) {
+			return "", errors.New("no endpoint available")
+		}))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	assert.ErrorContains(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()), "no endpoint available")
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestConsumeMetricsInvalidEndpointIsPermanent(t *testing.T) {
+	var calls atomic.Int64
+	exp, err := newCarbonExporter(
+		&Config{
+			TCPAddr:         confignet.TCPAddr{Endpoint: testutil.GetAvailableLocalAddress(t)},
+			TimeoutSettings: exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
+			RetryConfig: exporterhelper.RetrySettings{
+				Enabled:         true,
+				InitialInterval: 10 * time.Millisecond,
+				MaxInterval:     10 * time.Millisecond,
+				MaxElapsedTime:  time.Minute,
+			},
+		},
+		exportertest.NewNopCreateSettings(),
+		WithEndpointResolver(func(context.Context) (string, error) {
+			calls.Add(1)
+			// Missing the port, it can never be dialed.
+			return "localhost", nil
+		}))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	err = exp.ConsumeMetrics(context.Background(), generateSmallBatch())
+	require.Error(t, err)
+	assert.True(t, consumererror.IsPermanent(err))
+	// Permanent errors are not retried by the exporterhelper.
+	assert.EqualValues(t, 1, calls.Load())
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestConsumeMetricsErrorClassification(t *testing.T) {
+	// The space left in the name by sanitize_names: false can't be encoded
+	// with pickle.
+	unencodable := pmetric.NewMetrics()
+	m := unencodable.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("bad name")
+	m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+
+	tests := []struct {
+		name          string
+		md            pmetric.Metrics
+		wantPermanent bool
+	}{
+		{name: "format", md: unencodable, wantPermanent: true},
+		{name: "send", md: generateSmallBatch(), wantPermanent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			// Nothing is listening, so the writes fail.
+			cfg.Endpoint = testutil.GetAvailableLocalAddress(t)
+			cfg.Encoding = encodingPickle
+			cfg.SanitizeNames = false
+			cfg.QueueConfig.Enabled = false
+			cfg.RetryConfig.Enabled = false
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+			err = exp.ConsumeMetrics(context.Background(), tt.md)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantPermanent, consumererror.IsPermanent(err))
+			require.NoError(t, exp.Shutdown(context.Background()))
+		})
+	}
+}
+
+func TestConnPoolUnresolvableEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		rcode         byte
+		wantPermanent bool
+	}{
+		{name: "nxdomain", rcode: 3, wantPermanent: true},
+		{name: "servfail", rcode: 2, wantPermanent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = "carbon.invalid.:2003"
+			cp := newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{})
+			cp.dialer.Resolver = newFakeDNSResolver(tt.rcode)
+
+			_, err := cp.Write(context.Background(), []byte("a 1 0\n"))
+			assert.ErrorContains(t, err, `failed to resolve the host "carbon.invalid." of the endpoint`)
+			assert.Equal(t, tt.wantPermanent, consumererror.IsPermanent(err))
+		})
+	}
+}
+
+func TestConnPoolWriteTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		// Accept the connection but never read from it.
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	cfg.Timeout = 10 * time.Second
+	cfg.WriteTimeout = 200 * time.Millisecond
+	cp := newTCPConnPool(cfg, nil, newNopTelemetry(t), realClock{})
+	defer cp.Close()
+
+	// Large enough to fill the socket buffers.
+	start := time.Now()
+	_, err = cp.Write(context.Background(), make([]byte, 64<<20))
+	elapsed := time.Since(start)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.GreaterOrEqual(t, elapsed, cfg.WriteTimeout)
+	assert.Less(t, elapsed, cfg.Timeout)
+}
+
+func TestConnPoolReconnect(t *testing.T) {
+	// Larger than the socket buffers, so the writes fail once the server
+	// closes the connection.
+	var batch bytes.Buffer
+	lineCount := 0
+	for ; batch.Len() < 32<<20; lineCount++ {
+		batch.WriteString("test_" + strconv.Itoa(lineCount) + " 1 0\n")
+	}
+
+	tests := []struct {
+		name string
+		// brokenConns is the number of connections the server closes after
+		// reading the first lines.
+		brokenConns int
+		// serverDown stops the server once the connections are broken.
+		serverDown     bool
+		wantErr        bool
+		wantReconnects int64
+	}{
+		{name: "delivered", brokenConns: 2, wantReconnects: 2},
+		{name: "attempts_exhausted", brokenConns: 4, wantErr: true, wantReconnects: 3},
+		{name: "server_down", brokenConns: 1, serverDown: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			received := make(chan int, 1)
+			go func() {
+				for i := 0; ; i++ {
+					conn, acceptErr := ln.Accept()
+					if acceptErr != nil {
+						return
+					}
+					reader := bufio.NewReader(conn)
+					if i < tt.brokenConns {
+						for j := 0; j < 100; j++ {
+							_, _ = reader.ReadString('\n')
+						}
+						conn.Close()
+						if tt.serverDown && i == tt.brokenConns-1 {
+							ln.Close()
+							return
+						}
+						continue
+					}
+					lines := 0
+					for {
+						if _, readErr := reader.ReadString('\n'); readErr != nil {
+							break
+						}
+						lines++
+					}
+					conn.Close()
+					received <- lines
+					return
+				}
+			}()
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = ln.Addr().String()
+			cfg.Reconnect = ReconnectConfig{
+				Enabled:         true,
+				MaxAttempts:     3,
+				InitialInterval: 10 * time.Millisecond,
+				MaxInterval:     20 * time.Millisecond,
+				Multiplier:      2,
+			}
+			telemetry, reader := newTestTelemetrySettings()
+			exporterTelemetry, err := newExporterTelemetry(telemetry)
+			require.NoError(t, err)
+			cp := newTCPConnPool(cfg, nil, exporterTelemetry, realClock{})
+
+			n, err := cp.Write(context.Background(), batch.Bytes())
+			if tt.wantErr {
+				// The export gives up once the attempts are exhausted,
+				// reporting that nothing was written.
+				require.Error(t, err)
+				assert.Zero(t, n)
+				cp.Close()
+				// One error per attempt and one for the failed write.
+				assert.Equal(t, map[string]int64{"": 4}, collectSums(t, reader, "exporter_carbon_connection_errors", ""))
+				if tt.wantReconnects == 0 {
+					assert.Empty(t, collectSums(t, reader, "exporter_carbon_reconnects", ""))
+				} else {
+					assert.Equal(t, map[string]int64{"": tt.wantReconnects}, collectSums(t, reader, "exporter_carbon_reconnects", ""))
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, batch.Len(), n)
+			cp.Close()
+			// The whole batch is delivered on the last connection.
+			assert.Equal(t, lineCount, <-received)
+			assert.Equal(t, map[string]int64{"": tt.wantReconnects}, collectSums(t, reader, "exporter_carbon_reconnects", ""))
+		})
+	}
+}
+
+func TestConnPoolReconnectBackoffClock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		// Break the only connection and stop the server, so the attempts
+		// fail to dial.
+		conn, acceptErr := ln.Accept()
+		ln.Close()
+		if acceptErr != nil {
+			return
+		}
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	cfg.Reconnect = ReconnectConfig{
+		Enabled:         true,
+		MaxAttempts:     3,
+		InitialInterval: time.Hour,
+		MaxInterval:     time.Hour,
+		Multiplier:      1,
+	}
+	telemetry, reader := newTestTelemetrySettings()
+	exporterTelemetry, err := newExporterTelemetry(telemetry)
+	require.NoError(t, err)
+	clock := newFakeClock(time.Unix(0, 0))
+	cp := newTCPConnPool(cfg, nil, exporterTelemetry, clock)
+	defer cp.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// The waits only end when the clock moves: the first attempt fails
+		// to dial and the export is cancelled while waiting for the second.
+		for clock.tickerCount() < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Hour)
+		for clock.tickerCount() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	_, err = cp.Write(ctx, bytes.Repeat([]byte("test_0 1 0\n"), 4<<20))
+	assert.ErrorIs(t, err, context.Canceled)
+	// One error for the broken write and one for the failed attempt.
+	assert.Equal(t, map[string]int64{"": 2}, collectSums(t, reader, "exporter_carbon_connection_errors", ""))
+}
+
+// newFakeDNSResolver returns a resolver answering all the queries with the
+// given response code and no records.
+func newFakeDNSResolver(rcode byte) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				// Over a stream connection the messages are prefixed by their
+				// 2 bytes length.
+				var size [2]byte
+				if _, err := io.ReadFull(server, size[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(server, query); err != nil {
+					return
+				}
+
+				// Reply with the header and the question of the query, the
+				// question being the name followed by the type and the class.
+				end := 12
+				for query[end] != 0 {
+					end += int(query[end]) + 1
+				}
+				end += 5
+				reply := append([]byte(nil), query[:end]...)
+				reply[2] = 0x84 | query[2]&0x01 // QR and AA, RD as queried.
+				reply[3] = 0x80 | rcode         // RA and the response code.
+				for i := 6; i < 12; i++ {
+					// No answer, authority nor additional records.
+					reply[i] = 0
+				}
+				binary.BigEndian.PutUint16(size[:], uint16(len(reply)))
+				_, _ = server.Write(append(size[:], reply...))
+			}()
+			return client, nil
+		},
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "ready",
+			reply: "ok\n",
+			want:  "ping\ntest_0;k0=v0;k1=v1 0 ",
+		},
+		{
+			name:    "no_reply",
+			want:    "ping\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := testutil.GetAvailableLocalAddress(t)
+			ln, err := net.Listen("tcp", addr)
+			require.NoError(t, err)
+			defer ln.Close()
+
+			received := make(chan string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					received <- err.Error()
+					return
+				}
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				probe, _ := reader.ReadString('\n')
+				if tt.reply != "" {
+					_, _ = conn.Write([]byte(tt.reply))
+				}
+				rest, _ := io.ReadAll(reader)
+				received <- probe + string(rest)
+			}()
+
+			exp, err := newCarbonExporter(
+				&Config{
+					TCPAddr:            confignet.TCPAddr{Endpoint: addr},
+					TimeoutSettings:    exporterhelper.TimeoutSettings{Timeout: time.Second},
+					ReadinessProbeLine: "ping",
+				},
+				exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+			err = exp.ConsumeMetrics(context.Background(), generateSmallBatch())
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "readiness probe failed")
+			} else {
+				assert.NoError(t, err)
+			}
+			require.NoError(t, exp.Shutdown(context.Background()))
+
+			// Data is only written once the server replied to the probe.
+			got := <-received
+			if tt.wantErr {
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, strings.HasPrefix(got, tt.want), "unexpected data %q", got)
+			}
+		})
+	}
+}
+
+func TestCrossExportDedup(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	// The point is sent by the first export and again once the window ends.
+	cs.start(t, 2)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.CrossExportDedupWindow = time.Minute
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	set := exportertest.NewNopCreateSettings()
+	telemetry, reader := newTestTelemetrySettings()
+	set.TelemetrySettings = telemetry
+	exp, err := newCarbonExporter(cfg, set, WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	md := generateSmallBatch()
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	clock.Advance(30 * time.Second)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	dropped := collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey)
+	assert.EqualValues(t, 1, dropped[dropReasonDuplicate])
+
+	clock.Advance(30 * time.Second)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+}
+
+func TestCrossExportDedupSumRetried(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.RetryConfig.Enabled = false
+	cfg.CrossExportDedupWindow = time.Minute
+	cfg.ConcurrentDuplicatePolicy = duplicatePolicySum
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("requests")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(time.Unix(1701424800, 0)))
+	dp.SetIntValue(3)
+
+	// Nothing is listening yet, the first write fails.
+	require.Error(t, exp.ConsumeMetrics(context.Background(), md))
+
+	// The retry writes the value once, not added to the one of the failed
+	// export.
+	cs := newCarbonServer(t, addr, "requests 3 1701424800")
+	cs.start(t, 1)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+}
+
+func TestConnectionTelemetry(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.RetryConfig.Enabled = false
+	set := exportertest.NewNopCreateSettings()
+	telemetry, reader := newTestTelemetrySettings()
+	set.TelemetrySettings = telemetry
+	exp, err := newCarbonExporter(cfg, set)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Nothing is listening yet.
+	require.Error(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	assert.Equal(t, map[string]int64{"": 1}, collectSums(t, reader, "exporter_carbon_connection_errors", ""))
+
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	cs.start(t, 1)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+
+	lines := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), generateSmallBatch())
+	assert.Equal(t, map[string]int64{"": int64(len(lines))}, collectSums(t, reader, "exporter_carbon_bytes_sent", ""))
+	assert.Equal(t, map[string]int64{"": 1}, collectSums(t, reader, "exporter_carbon_lines_sent", ""))
+	assert.Equal(t, map[string]int64{"": 1}, collectSums(t, reader, "exporter_carbon_connection_errors", ""))
+	// The failed attempt didn't create a connection, so there is nothing to
+	// replace.
+	assert.Empty(t, collectSums(t, reader, "exporter_carbon_reconnects", ""))
+}
+
+func TestConsumeMetricsRetriesUntilServerIsBack(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.RetryConfig.InitialInterval = 10 * time.Millisecond
+	cfg.RetryConfig.MaxInterval = 50 * time.Millisecond
+	set := exportertest.NewNopCreateSettings()
+	telemetry, reader := newTestTelemetrySettings()
+	set.TelemetrySettings = telemetry
+	exp, err := newCarbonExporter(cfg, set)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Nothing is listening yet, the batch is queued and retried instead of
+	// being dropped.
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.Eventually(t, func() bool {
+		return collectSums(t, reader, "exporter_carbon_connection_errors", "")[""] > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cs := newCarbonServer(t, addr, "test_0;k0=v0;k1=v1 0")
+	cs.start(t, 1)
+	cs.shutdownAndVerify(t)
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestEmitUptime(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.Prefix = "prod"
+	cfg.EmitUptime = UptimeConfig{Enabled: true, Interval: 10 * time.Second}
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Nothing is sent before the first interval elapsed.
+	clock.Advance(5 * time.Second)
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, "prod.collector.uptime_seconds 10 1701424810\n", <-lines)
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "prod.collector.uptime_seconds 20 1701424820\n", <-lines)
+
+	require.NoError(t, exp.Shutdown(context.Background()))
+	// No more lines are sent once shut down.
+	clock.Advance(10 * time.Second)
+	_, ok := <-lines
+	assert.False(t, ok)
+}
+
+func TestEmitBackpressureDrops(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.Prefix = "prod"
+	cfg.QueueConfig.NumConsumers = 1
+	cfg.QueueConfig.QueueSize = 1
+	cfg.EmitBackpressureDrops = BackpressureDropsConfig{Enabled: true, Interval: 10 * time.Second}
+	// The startup jitter holds the queue consumer, filling the queue.
+	cfg.StartupJitter = time.Hour
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	random := func(opts *factoryOptions) {
+		opts.random = func() float64 { return 0.5 }
+	}
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock), random)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// One batch is held by the consumer and one is queued, depending on when
+	// the consumer picks up the first one the next batches are refused.
+	dropped := 0
+	for i := 0; i < 5; i++ {
+		md := generateMetricsBatch(3)
+		points := md.DataPointCount()
+		if exp.ConsumeMetrics(context.Background(), md) != nil {
+			dropped += points
+		}
+	}
+	require.Greater(t, dropped, 0)
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, fmt.Sprintf("prod.collector.backpressure_drops %d 1701424810\n", dropped), <-lines)
+
+	// Release the queued batches before shutting down.
+	go func() {
+		for range lines {
+		}
+	}()
+	clock.Advance(time.Hour)
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestSeriesCount(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.Prefix = "prod"
+	cfg.QueueConfig.Enabled = false
+	cfg.SeriesCountInterval = 10 * time.Second
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	export := func(points map[string]string) {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		dps := m.SetEmptyGauge().DataPoints()
+		for key, value := range points {
+			dp := dps.AppendEmpty()
+			dp.Attributes().PutStr(key, value)
+			dp.SetIntValue(1)
+		}
+		require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+		for range points {
+			<-lines
+		}
+	}
+	// Three distinct series across both exports.
+	export(map[string]string{"host": "a", "region": "eu"})
+	export(map[string]string{"host": "a", "zone": "1"})
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "prod.collector.active_series 3 1701424810\n", <-lines)
+	// The count starts over with each line.
+	export(map[string]string{"host": "b"})
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "prod.collector.active_series 1 1701424820\n", <-lines)
+
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestRepeatLastValue(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.QueueConfig.Enabled = false
+	cfg.RepeatLastValue = RepeatLastValueConfig{
+		Enabled:      true,
+		Interval:     10 * time.Second,
+		StalenessTTL: 30 * time.Second,
+		MaxSeries:    10,
+	}
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("temperature")
+	dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(clock.Now()))
+	dp.SetDoubleValue(21.5)
+	// Only the gauges are repeated.
+	sum := ms.AppendEmpty()
+	sum.SetName("requests")
+	sum.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(1)
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	assert.Equal(t, "temperature 21.5 1701424800\n", <-lines)
+	assert.Equal(t, "requests 1 0\n", <-lines)
+
+	// Without new data the last value is repeated on each tick.
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "temperature 21.5 1701424810\n", <-lines)
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, "temperature 21.5 1701424820\n", <-lines)
+
+	// Until it becomes stale.
+	clock.Advance(10 * time.Second)
+	require.NoError(t, exp.Shutdown(context.Background()))
+	_, ok := <-lines
+	assert.False(t, ok)
+}
+
+// startLineServer accepts a single connection and sends each line read from
+// it on the returned channel, which is closed once the connection is closed.
+func startLineServer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	lines := make(chan string)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, readErr := reader.ReadString('\n')
+			if readErr != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	return ln.Addr().String(), lines
+}
+
+func TestConsumeMetricsMetricTypes(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.MetricTypes = []string{"gauge", "sum"}
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("temperature")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	histogram := ms.AppendEmpty()
+	histogram.SetName("latency")
+	hdp := histogram.SetEmptyHistogram().DataPoints().AppendEmpty()
+	hdp.SetCount(1)
+	hdp.SetSum(2)
+	summary := ms.AppendEmpty()
+	summary.SetName("size")
+	sdp := summary.SetEmptySummary().DataPoints().AppendEmpty()
+	sdp.SetCount(1)
+	sdp.SetSum(3)
+	sum := ms.AppendEmpty()
+	sum.SetName("requests")
+	sum.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(4)
+	// Skipping the disabled types is not an error.
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	var received []string
+	for line := range lines {
+		received = append(received, line)
+	}
+	assert.Equal(t, []string{"temperature 1 0\n", "requests 4 0\n"}, received)
+}
+
+func TestConsumeMetricsNonFiniteValues(t *testing.T) {
+	addr, lines := startLineServer(t)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dps := m.SetEmptyGauge().DataPoints()
+	for _, v := range []float64{math.NaN(), math.Inf(1), 2} {
+		dps.AppendEmpty().SetDoubleValue(v)
+	}
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	// Only the finite value is received, as a well-formed line.
+	var received []string
+	for line := range lines {
+		received = append(received, line)
+		fields := strings.Fields(line)
+		require.Len(t, fields, 3)
+		value, err := strconv.ParseFloat(fields[1], 64)
+		require.NoError(t, err)
+		assert.False(t, math.IsNaN(value) || math.IsInf(value, 0), line)
+	}
+	assert.Equal(t, []string{"gauge 2 0\n"}, received)
+}
+
+func TestConsumeMetricsHistogram(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		// Read until the exporter closes the connection on Shutdown.
+		buf, _ := io.ReadAll(conn)
+		received <- buf
+	}()
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("latency")
+	dp := m.SetEmptyHistogram().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(time.Unix(1701424800, 0)))
+	dp.Attributes().PutStr("k0", "v0")
+	dp.ExplicitBounds().FromRaw([]float64{0.1, 1})
+	dp.BucketCounts().FromRaw([]uint64{3, 2, 1})
+	dp.SetCount(6)
+	dp.SetSum(4.5)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	assert.Equal(t, "latency.count;k0=v0 6 1701424800\n"+
+		"latency;k0=v0 4.5 1701424800\n"+
+		"latency.bucket;k0=v0;upper_bound=0.1 3 1701424800\n"+
+		"latency.bucket;k0=v0;upper_bound=1 2 1701424800\n"+
+		"latency.bucket;k0=v0;upper_bound=inf 1 1701424800\n", string(<-received))
+}
+
+func TestShutdownMarker(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		// Read until the exporter closes the connection on Shutdown.
+		buf, _ := io.ReadAll(conn)
+		received <- buf
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = addr
+	cfg.EmitShutdownMarker = true
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings(), WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	lines := strings.Split(strings.TrimSuffix(string(<-received), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "test_0;k0=v0;k1=v1 0")
+	assert.Equal(t, "collector.shutdown 1 1701424800", lines[1])
+}
+
+func TestShutdownConnectionClosedDuringDrain(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_%t", strict), func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			flushed := make(chan struct{})
+			go func() {
+				defer close(flushed)
+				conn, acceptErr := ln.Accept()
+				if acceptErr != nil {
+					return
+				}
+				// Carbon goes away once the queued batch is flushed.
+				_, _ = bufio.NewReader(conn).ReadString('\n')
+				conn.Close()
+				ln.Close()
+			}()
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = ln.Addr().String()
+			cfg.EmitShutdownMarker = true
+			cfg.StrictShutdown = strict
+			cfg.RetryConfig.Enabled = false
+			core, logs := observer.New(zap.WarnLevel)
+			set := exportertest.NewNopCreateSettings()
+			set.Logger = zap.New(core)
+			exp, err := newCarbonExporter(cfg, set)
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+			require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+			<-flushed
+
+			err = exp.Shutdown(context.Background())
+			if strict {
+				assert.ErrorContains(t, err, "failed to send the shutdown marker")
+				assert.Zero(t, logs.FilterMessage("Failed to send the shutdown marker").Len())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 1, logs.FilterMessage("Failed to send the shutdown marker").Len())
+		})
+	}
+}
+
+func TestShutdownServerNotReading(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		// Carbon accepts the connection but never reads from it.
+		<-stop
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	cfg.Timeout = 500 * time.Millisecond
+	cfg.EmitShutdownMarker = true
+	cfg.StrictShutdown = true
+	cfg.RetryConfig.Enabled = false
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, exp.ConsumeMetrics(context.Background(), generateMetricsBatch(10000)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The result depends on how much the socket buffers hold, only
+		// returning matters.
+		_ = exp.Shutdown(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		assert.Fail(t, "the shutdown blocked on a server that stopped reading")
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		mtu  int
+		// wantDatagrams holds the number of lines of each datagram.
+		wantDatagrams []int
+	}{
+		{
+			name:          "line_per_datagram",
+			wantDatagrams: []int{1, 1, 1, 1},
+		},
+		{
+			// Each line takes 32 bytes.
+			name:          "coalesced",
+			mtu:           70,
+			wantDatagrams: []int{2, 2},
+		},
+		{
+			name:          "single_datagram",
+			mtu:           1400,
+			wantDatagrams: []int{4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			require.NoError(t, err)
+			defer ln.Close()
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = ln.LocalAddr().String()
+			cfg.Transport = transportUDP
+			cfg.MTU = tt.mtu
+			cfg.QueueConfig.Enabled = false
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(t, err)
+			require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+			require.NoError(t, exp.ConsumeMetrics(context.Background(), generateMetricsBatch(4)))
+			require.NoError(t, exp.Shutdown(context.Background()))
+
+			var lines []string
+			buf := make([]byte, 65536)
+			for _, wantLines := range tt.wantDatagrams {
+				require.NoError(t, ln.SetReadDeadline(time.Now().Add(5*time.Second)))
+				n, _, err := ln.ReadFromUDP(buf)
+				require.NoError(t, err)
+				datagram := strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")
+				assert.Len(t, datagram, wantLines)
+				lines = append(lines, datagram...)
+			}
+			require.Len(t, lines, 4)
+			for i, line := range lines {
+				assert.Contains(t, line, "test_"+strconv.Itoa(i)+";k0=v0;k1=v1 "+strconv.Itoa(i)+" ")
+			}
+		})
+	}
+}
+
+func TestUnixTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "carbon.sock")
+	cs := newUnixCarbonServer(t, path, "test_0;k0=v0;k1=v1 0")
+	cs.start(t, 2)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = path
+	cfg.Transport = transportUnix
+	require.NoError(t, cfg.Validate())
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+	cs.shutdownAndVerify(t)
+	// The connection is reused across the exports.
+	assert.EqualValues(t, 1, cs.accepted.Load())
+}
+
+func TestTLS(t *testing.T) {
+	cert, caFile := generateTestCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	cfg := createDefaultConfig().(*Config)
+	// The certificate is verified against the host of the endpoint.
+	cfg.Endpoint = net.JoinHostPort("localhost", port)
+	cfg.TLSSetting = &configtls.TLSClientSetting{TLSSetting: configtls.TLSSetting{CAFile: caFile}}
+	cfg.QueueConfig.Enabled = false
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
+
+	select {
+	case line := <-received:
+		assert.Contains(t, line, "test_0;k0=v0;k1=v1 0 ")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no data received")
+	}
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// The server accepts the connections but never completes the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	cfg.Timeout = 100 * time.Millisecond
+	cfg.TLSSetting = &configtls.TLSClientSetting{}
+	cfg.QueueConfig.Enabled = false
+	cfg.RetryConfig.Enabled = false
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+
+	start := time.Now()
+	err = exp.ConsumeMetrics(context.Background(), generateSmallBatch())
+	assert.ErrorContains(t, err, "TLS handshake failed")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+func TestTLSUnreadableCertificate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TLSSetting = &configtls.TLSClientSetting{TLSSetting: configtls.TLSSetting{
+		CertFile: filepath.Join(t.TempDir(), "missing.crt"),
+		KeyFile:  filepath.Join(t.TempDir(), "missing.key"),
+	}}
+	exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+	require.NoError(t, err)
+	assert.Error(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+}
+
+// generateTestCertificate returns a self-signed certificate for localhost
+// and the path of the PEM file holding it, to be used as CA.
+func generateTestCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestConsumeMetricsBatchEndsWithNewline(t *testing.T) {
+	addr := testutil.GetAvailableLocalAddress(t)
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		// Read until the exporter closes the connection on Shutdown.
+		buf, _ := io.ReadAll(conn)
+		received <- buf
+	}()
 
 	exp, err := newCarbonExporter(
 		&Config{
-			TCPAddr:                   confignet.TCPAddr{Endpoint: addr},
-			TimeoutSettings:           exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
-			ResourceToTelemetryConfig: resourcetotelemetry.Settings{Enabled: true},
+			TCPAddr:         confignet.TCPAddr{Endpoint: addr},
+			TimeoutSettings: exporterhelper.TimeoutSettings{Timeout: 5 * time.Second},
 		},
 		exportertest.NewNopCreateSettings())
 	require.NoError(t, err)
 	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
-	require.NoError(t, exp.ConsumeMetrics(context.Background(), generateSmallBatch()))
-	assert.NoError(t, exp.Shutdown(context.Background()))
-	cs.shutdownAndVerify(t)
+	md := generateLargeBatch()
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), md))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	buf := <-received
+	require.NotEmpty(t, buf)
+	assert.Equal(t, byte('\n'), buf[len(buf)-1])
+	assert.Equal(t, md.DataPointCount(), bytes.Count(buf, []byte("\n")))
 }
 
 func TestConsumeMetrics(t *testing.T) {
@@ -154,6 +1961,107 @@ func TestConsumeMetrics(t *testing.T) {
 	}
 }
 
+func BenchmarkConsumeMetricsMaxConnections(b *testing.B) {
+	for _, maxConns := range []int{1, 10} {
+		b.Run("max_connections_"+strconv.Itoa(maxConns), func(b *testing.B) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(b, err)
+			defer ln.Close()
+			go func() {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						// Each connection is read at a bounded rate, as a busy
+						// Carbon server would, so the throughput grows with
+						// the number of connections.
+						buf := make([]byte, 4096)
+						for {
+							if _, err := conn.Read(buf); err != nil {
+								return
+							}
+							time.Sleep(100 * time.Microsecond)
+						}
+					}()
+				}
+			}()
+
+			cfg := createDefaultConfig().(*Config)
+			cfg.Endpoint = ln.Addr().String()
+			cfg.QueueConfig.Enabled = false
+			cfg.MaxConnections = maxConns
+			exp, err := newCarbonExporter(cfg, exportertest.NewNopCreateSettings())
+			require.NoError(b, err)
+			require.NoError(b, exp.Start(context.Background(), componenttest.NewNopHost()))
+			md := generateLargeBatch()
+
+			b.SetParallelism(10)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := exp.ConsumeMetrics(context.Background(), md); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+			b.StopTimer()
+			require.NoError(b, exp.Shutdown(context.Background()))
+		})
+	}
+}
+
+// BenchmarkConnPoolWrite compares writing the lines of an export one by one
+// with the single write of the whole export done by the exporter.
+func BenchmarkConnPoolWrite(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = ln.Addr().String()
+	plaintext := newTestFormatter(b, cfg).metricDataToPlaintext(context.Background(), generateLargeBatch())
+	lines := strings.SplitAfter(strings.TrimSuffix(plaintext, "\n"), "\n")
+
+	b.Run("per_line", func(b *testing.B) {
+		cp := newTCPConnPool(cfg, nil, newNopTelemetry(b), realClock{})
+		defer cp.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				if _, err := cp.Write(context.Background(), []byte(line)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		cp := newTCPConnPool(cfg, nil, newNopTelemetry(b), realClock{})
+		defer cp.Close()
+		data := []byte(plaintext)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := cp.Write(context.Background(), data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func generateSmallBatch() pmetric.Metrics {
 	return generateMetricsBatch(1)
 }
@@ -183,10 +2091,16 @@ func generateMetricsBatch(size int) pmetric.Metrics {
 }
 
 type carbonServer struct {
-	ln                    *net.TCPListener
+	ln                    net.Listener
 	doneServer            *atomic.Bool
-	wg                    sync.WaitGroup
 	expectedContainsValue string
+	// received counts the lines read, allReceived is closed once it reaches
+	// expected.
+	expected    int64
+	received    atomic.Int64
+	allReceived chan struct{}
+	// accepted counts the connections accepted by the server.
+	accepted atomic.Int64
 }
 
 func newCarbonServer(t *testing.T, addr string, expectedContainsValue string) *carbonServer {
@@ -201,8 +2115,24 @@ func newCarbonServer(t *testing.T, addr string, expectedContainsValue string) *c
 	}
 }
 
+// newUnixCarbonServer is newCarbonServer listening on the Unix socket at
+// path.
+func newUnixCarbonServer(t *testing.T, path string, expectedContainsValue string) *carbonServer {
+	ln, err := net.ListenUnix(transportUnix, &net.UnixAddr{Name: path, Net: transportUnix})
+	require.NoError(t, err)
+	return &carbonServer{
+		ln:                    ln,
+		doneServer:            &atomic.Bool{},
+		expectedContainsValue: expectedContainsValue,
+	}
+}
+
 func (cs *carbonServer) start(t *testing.T, numExpectedReq int) {
-	cs.wg.Add(numExpectedReq)
+	cs.expected = int64(numExpectedReq)
+	cs.allReceived = make(chan struct{})
+	if numExpectedReq == 0 {
+		close(cs.allReceived)
+	}
 	go func() {
 		for {
 			conn, err := cs.ln.Accept()
@@ -211,6 +2141,7 @@ func (cs *carbonServer) start(t *testing.T, numExpectedReq int) {
 				return
 			}
 			require.NoError(t, err)
+			cs.accepted.Add(1)
 			go func(conn net.Conn) {
 				defer func() {
 					require.NoError(t, conn.Close())
@@ -228,7 +2159,11 @@ func (cs *carbonServer) start(t *testing.T, numExpectedReq int) {
 						assert.Contains(t, string(buf), cs.expectedContainsValue)
 					}
 
-					cs.wg.Done()
+					// Lines received past the expected ones don't close
+					// allReceived again.
+					if cs.received.Add(1) == cs.expected {
+						close(cs.allReceived)
+					}
 				}
 			}(conn)
 		}
@@ -236,8 +2171,14 @@ func (cs *carbonServer) start(t *testing.T, numExpectedReq int) {
 	<-time.After(100 * time.Millisecond)
 }
 
+// shutdownAndVerify waits for the expected lines, failing the test instead of
+// hanging when some of them never arrive.
 func (cs *carbonServer) shutdownAndVerify(t *testing.T) {
-	cs.wg.Wait()
+	select {
+	case <-cs.allReceived:
+	case <-time.After(30 * time.Second):
+		assert.Failf(t, "missing lines", "received %d of the %d lines expected", cs.received.Load(), cs.expected)
+	}
 	cs.doneServer.Store(true)
 	require.NoError(t, cs.ln.Close())
 }
@@ -5,6 +5,7 @@ package carbonexporter // import "github.com/open-telemetry/opentelemetry-collec
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/confignet"
@@ -14,12 +15,69 @@ import (
 	"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter/internal/metadata"
 )
 
+// FactoryOption applies changes to the exporters created by the factory.
+type FactoryOption func(opts *factoryOptions)
+
+// factoryOptions holds the settings that embedders can change via
+// FactoryOption, they are not exposed as part of Config.
+type factoryOptions struct {
+	endpointResolver func(context.Context) (string, error)
+	clock            Clock
+	flushErrHandler  func(err error, lines int)
+	bufferPool       BufferPool
+	// random returns a pseudo-random number in [0.0, 1.0), it is only
+	// replaced by tests.
+	random func() float64
+}
+
+// WithEndpointResolver sets a function called before each new connection to
+// pick the endpoint to dial, e.g. from a service discovery mechanism. When not
+// set the exporter always dials the configured endpoint. The resolver is only
+// used with the "tcp" transport.
+func WithEndpointResolver(resolver func(context.Context) (string, error)) FactoryOption {
+	return func(opts *factoryOptions) {
+		opts.endpointResolver = resolver
+	}
+}
+
+// WithClock sets the Clock used by the time based features of the exporter,
+// e.g. max_point_age. When not set the exporter uses the system clock.
+func WithClock(clock Clock) FactoryOption {
+	return func(opts *factoryOptions) {
+		opts.clock = clock
+	}
+}
+
+// WithFlushErrorHandler sets a function called each time writing a batch to
+// the backend fails, with the error and the number of lines in the batch, e.g.
+// to raise an alert or send the data elsewhere. The error is still returned to
+// the pipeline as usual.
+func WithFlushErrorHandler(handler func(err error, lines int)) FactoryOption {
+	return func(opts *factoryOptions) {
+		opts.flushErrHandler = handler
+	}
+}
+
+// WithBufferPool sets the BufferPool providing the buffers the data is
+// serialized into. When not set the exporter uses a pool backed by a
+// sync.Pool.
+func WithBufferPool(pool BufferPool) FactoryOption {
+	return func(opts *factoryOptions) {
+		opts.bufferPool = pool
+	}
+}
+
 // NewFactory creates a factory for Carbon exporter.
-func NewFactory() exporter.Factory {
+func NewFactory(options ...FactoryOption) exporter.Factory {
+	f := &carbonExporterFactory{options: options}
 	return exporter.NewFactory(
 		metadata.Type,
 		createDefaultConfig,
-		exporter.WithMetrics(createMetricsExporter, metadata.MetricsStability))
+		exporter.WithMetrics(f.createMetricsExporter, metadata.MetricsStability))
+}
+
+type carbonExporterFactory struct {
+	options []FactoryOption
 }
 
 func createDefaultConfig() component.Config {
@@ -27,18 +85,66 @@ func createDefaultConfig() component.Config {
 		TCPAddr: confignet.TCPAddr{
 			Endpoint: defaultEndpoint,
 		},
-		TimeoutSettings: exporterhelper.NewDefaultTimeoutSettings(),
-		QueueConfig:     exporterhelper.NewDefaultQueueSettings(),
-		RetryConfig:     exporterhelper.NewDefaultRetrySettings(),
+		TimeoutSettings:           exporterhelper.NewDefaultTimeoutSettings(),
+		QueueConfig:               exporterhelper.NewDefaultQueueSettings(),
+		RetryConfig:               exporterhelper.NewDefaultRetrySettings(),
+		TagKeyCollisionPolicy:     tagKeyCollisionKeepFirst,
+		DualStack:                 true,
+		TagSeparator:              defaultTagSeparator,
+		TagKVSeparator:            defaultTagKVSeparator,
+		Separator:                 pathSeparator,
+		ShutdownDrainPolicy:       shutdownDrainFlush,
+		NoDelay:                   true,
+		UnicodePolicy:             unicodePolicyKeep,
+		MetricsFormat:             metricsFormatTags,
+		NaNHandling:               nanHandlingSkip,
+		ConcurrentDuplicatePolicy: duplicatePolicyDrop,
+		DefaultTemporality:        temporalityCumulative,
+		EnvironmentInPath:         EnvironmentInPathConfig{Default: defaultEnvironment},
+		Reconnect: ReconnectConfig{
+			MaxAttempts:     3,
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     5 * time.Second,
+			Multiplier:      2,
+		},
+		SanitizeNames:              true,
+		SanitizeReplacement:        string(sanitizedRune),
+		CrossExportDedupMaxEntries: defaultCrossExportDedupMaxEntries,
+		StartRetry: StartRetryConfig{
+			Interval:       time.Second,
+			MaxElapsedTime: time.Minute,
+		},
+		EmitMovingAverage: MovingAverageConfig{
+			Window:       5,
+			StalenessTTL: 10 * time.Minute,
+			MaxSeries:    10000,
+		},
+		EmitUptime: UptimeConfig{
+			Interval: time.Minute,
+		},
+		EmitBackpressureDrops: BackpressureDropsConfig{
+			Interval: time.Minute,
+		},
+		SeriesCountMaxSeries: defaultSeriesCountMaxSeries,
+		RepeatLastValue: RepeatLastValueConfig{
+			Interval:     time.Minute,
+			StalenessTTL: 10 * time.Minute,
+			MaxSeries:    10000,
+		},
+		SumConversion: SumConversionConfig{
+			Mode:         sumConversionNone,
+			StalenessTTL: 10 * time.Minute,
+			MaxSeries:    10000,
+		},
 	}
 }
 
-func createMetricsExporter(
+func (f *carbonExporterFactory) createMetricsExporter(
 	_ context.Context,
 	params exporter.CreateSettings,
 	config component.Config,
 ) (exporter.Metrics, error) {
-	exp, err := newCarbonExporter(config.(*Config), params)
+	exp, err := newCarbonExporter(config.(*Config), params, f.options...)
 
 	if err != nil {
 		return nil, err
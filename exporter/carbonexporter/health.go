@@ -0,0 +1,60 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+)
+
+// healthReporter reports the exporter as unhealthy, ie.: with a recoverable
+// error status, once threshold consecutive writes failed and as healthy again
+// on the next successful write, so a single transient error doesn't make the
+// status flap.
+type healthReporter struct {
+	mtx       sync.Mutex
+	report    component.StatusFunc
+	threshold int
+	failures  int
+	unhealthy bool
+}
+
+// newHealthReporter returns nil, which reports nothing, when threshold is not
+// positive or there is no way to report the status.
+func newHealthReporter(report component.StatusFunc, threshold int) *healthReporter {
+	if threshold <= 0 || report == nil {
+		return nil
+	}
+	return &healthReporter{
+		report:    report,
+		threshold: threshold,
+	}
+}
+
+// recordWrite accounts for the result of a write, reporting the status only
+// when it changes.
+func (h *healthReporter) recordWrite(err error) {
+	if h == nil {
+		return
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if err == nil {
+		h.failures = 0
+		if h.unhealthy {
+			h.unhealthy = false
+			_ = h.report(component.NewStatusEvent(component.StatusOK))
+		}
+		return
+	}
+
+	h.failures++
+	if !h.unhealthy && h.failures >= h.threshold {
+		h.unhealthy = true
+		_ = h.report(component.NewRecoverableErrorEvent(err))
+	}
+}
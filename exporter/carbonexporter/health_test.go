@@ -0,0 +1,55 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+)
+
+func TestHealthReporter(t *testing.T) {
+	var events []*component.StatusEvent
+	h := newHealthReporter(func(ev *component.StatusEvent) error {
+		events = append(events, ev)
+		return nil
+	}, 3)
+	require.NotNil(t, h)
+	writeErr := errors.New("connection refused")
+
+	// A single failure, or failures interrupted by a success, don't flip the
+	// status.
+	h.recordWrite(writeErr)
+	h.recordWrite(writeErr)
+	h.recordWrite(nil)
+	h.recordWrite(writeErr)
+	assert.Empty(t, events)
+
+	// Three consecutive failures do, and the status is only reported once.
+	h.recordWrite(writeErr)
+	h.recordWrite(writeErr)
+	h.recordWrite(writeErr)
+	require.Len(t, events, 1)
+	assert.Equal(t, component.StatusRecoverableError, events[0].Status())
+	assert.ErrorIs(t, events[0].Err(), writeErr)
+
+	// The next success reports the exporter healthy again.
+	h.recordWrite(nil)
+	h.recordWrite(nil)
+	require.Len(t, events, 2)
+	assert.Equal(t, component.StatusOK, events[1].Status())
+}
+
+func TestHealthReporterDisabled(t *testing.T) {
+	report := func(*component.StatusEvent) error { return nil }
+	assert.Nil(t, newHealthReporter(report, 0))
+	assert.Nil(t, newHealthReporter(nil, 3))
+
+	// A nil reporter ignores the writes.
+	var h *healthReporter
+	h.recordWrite(errors.New("connection refused"))
+}
@@ -0,0 +1,80 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lastValues remembers the last value of each gauge series, identified by
+// its path, so it can be repeated while no new value arrives. A series is
+// forgotten once its last value is staleAfter old. At most maxSeries series
+// are remembered, the least recently updated ones are forgotten first.
+type lastValues struct {
+	mu         sync.Mutex
+	staleAfter time.Duration
+	maxSeries  int
+	// entries holds the lastValue values by increasing updatedAt.
+	entries *list.List
+	series  map[string]*list.Element
+}
+
+type lastValue struct {
+	path      string
+	value     string
+	updatedAt time.Time
+}
+
+func newLastValues(staleAfter time.Duration, maxSeries int) *lastValues {
+	return &lastValues{
+		staleAfter: staleAfter,
+		maxSeries:  maxSeries,
+		entries:    list.New(),
+		series:     make(map[string]*list.Element),
+	}
+}
+
+// update sets the last value of the series with the given path.
+func (lv *lastValues) update(path, value string, now time.Time) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	if e, ok := lv.series[path]; ok {
+		e.Value = lastValue{path: path, value: value, updatedAt: now}
+		lv.entries.MoveToBack(e)
+		return
+	}
+	lv.series[path] = lv.entries.PushBack(lastValue{path: path, value: value, updatedAt: now})
+	if lv.entries.Len() > lv.maxSeries {
+		lv.remove(lv.entries.Front())
+	}
+}
+
+// due forgets the stale series and returns the others not updated within
+// the last interval, the ones to repeat.
+func (lv *lastValues) due(now time.Time, interval time.Duration) []lastValue {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	for e := lv.entries.Front(); e != nil && now.Sub(e.Value.(lastValue).updatedAt) >= lv.staleAfter; e = lv.entries.Front() {
+		lv.remove(e)
+	}
+
+	var due []lastValue
+	for e := lv.entries.Front(); e != nil; e = e.Next() {
+		v := e.Value.(lastValue)
+		if now.Sub(v.updatedAt) < interval {
+			break
+		}
+		due = append(due, v)
+	}
+	return due
+}
+
+func (lv *lastValues) remove(e *list.Element) {
+	delete(lv.series, e.Value.(lastValue).path)
+	lv.entries.Remove(e)
+}
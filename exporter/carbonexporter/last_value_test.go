@@ -0,0 +1,43 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastValues(t *testing.T) {
+	start := time.Unix(0, 0)
+	lv := newLastValues(time.Minute, 2)
+	lines := func(due []lastValue) []string {
+		var got []string
+		for _, v := range due {
+			got = append(got, v.path+" "+v.value)
+		}
+		return got
+	}
+
+	lv.update("a", "1", start)
+	lv.update("b", "1", start.Add(5*time.Second))
+	// Only the series not updated within the interval are due.
+	assert.Equal(t, []string{"a 1"}, lines(lv.due(start.Add(10*time.Second), 10*time.Second)))
+	assert.Equal(t, []string{"a 1", "b 1"}, lines(lv.due(start.Add(20*time.Second), 10*time.Second)))
+
+	// Updating a series resets its staleness.
+	lv.update("a", "2", start.Add(30*time.Second))
+	assert.Equal(t, []string{"b 1", "a 2"}, lines(lv.due(start.Add(40*time.Second), 10*time.Second)))
+	assert.Equal(t, []string{"a 2"}, lines(lv.due(start.Add(65*time.Second), 10*time.Second)))
+	assert.Empty(t, lv.due(start.Add(90*time.Second), 10*time.Second))
+
+	// The least recently updated series are forgotten beyond maxSeries.
+	now := start.Add(2 * time.Minute)
+	lv.update("c", "1", now)
+	lv.update("d", "1", now)
+	lv.update("c", "2", now)
+	lv.update("e", "1", now)
+	assert.Equal(t, []string{"c 2", "e 1"}, lines(lv.due(now, 0)))
+}
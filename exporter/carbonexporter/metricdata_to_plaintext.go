@@ -4,41 +4,579 @@
 package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	conventions "go.opentelemetry.io/collector/semconv/v1.9.0"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
 	// sanitizedRune is used to replace any invalid char per Carbon format.
 	sanitizedRune = '_'
 
-	// Tag related constants per Carbon plaintext protocol.
-	tagPrefix                = ";"
-	tagKeyValueSeparator     = "="
+	// Tag related constants per Carbon plaintext protocol, the separators can
+	// be changed via Config.TagSeparator and Config.TagKVSeparator.
+	defaultTagSeparator      = ";"
+	defaultTagKVSeparator    = "="
 	tagValueEmptyPlaceholder = "<empty>"
 
+	// tagValueConcatSeparator joins the values of colliding tag keys when the
+	// "concat-values" collision policy is used.
+	tagValueConcatSeparator = ","
+
+	// defaultEnvironment is the default of Config.EnvironmentInPath.Default.
+	defaultEnvironment = "unknown"
+
 	// Constants used when converting from distribution metrics to Carbon format.
-	distributionBucketSuffix             = ".bucket"
-	distributionUpperBoundTagKey         = "upper_bound"
-	distributionUpperBoundTagBeforeValue = tagPrefix + distributionUpperBoundTagKey + tagKeyValueSeparator
+	distributionBucketSuffix     = ".bucket"
+	distributionUpperBoundTagKey = "upper_bound"
 
 	// Constants used when converting from summary metrics to Carbon format.
-	summaryQuantileSuffix         = ".quantile"
-	summaryQuantileTagKey         = "quantile"
-	summaryQuantileTagBeforeValue = tagPrefix + summaryQuantileTagKey + tagKeyValueSeparator
+	summaryQuantileSuffix = ".quantile"
+	summaryQuantileTagKey = "quantile"
 
 	// Suffix to be added to original metric name for a Carbon metric representing
 	// a count metric for either distribution or summary metrics.
 	countSuffix = ".count"
 
+	// Suffix added to the name of a gauge for the line carrying its moving
+	// average.
+	movingAverageSuffix = ".avg"
+
+	// Tag key and values used to hint Graphite on how to roll up a series.
+	aggregationMethodTagKey  = "aggregationMethod"
+	aggregationMethodSum     = "sum"
+	aggregationMethodAverage = "average"
+	aggregationMethodLast    = "last"
+	aggregationMethodMax     = "max"
+
+	// Tag key used for Config.PipelineTag.
+	pipelineTagKey = "pipeline"
+
+	// Tag key used for Config.RetentionClasses.
+	retentionTagKey = "retention"
+
+	// Tag key used for Config.ResourceAttributesAsJSONTag.
+	resourceTagKey = "resource"
+
+	// Path and tag keys of the lines emitted when Config.EmitScopeMeta is
+	// enabled.
+	scopeMetaPath          = "otel.scope"
+	scopeMetaNameTagKey    = "name"
+	scopeMetaVersionTagKey = "version"
+
+	// Tag key used for Config.IncludeScopeVersionTag.
+	scopeVersionTagKey = "scope_version"
+
+	// Path of the line sent on shutdown when Config.EmitShutdownMarker is
+	// enabled.
+	shutdownMarkerPath = "collector.shutdown"
+
+	// Path, after Config.Prefix, of the line sent periodically when
+	// Config.EmitUptime is enabled.
+	uptimePath = "collector.uptime_seconds"
+
+	// Path, after Config.Prefix, of the line sent periodically when
+	// Config.EmitBackpressureDrops is enabled.
+	backpressureDropsPath = "collector.backpressure_drops"
+
+	// Path, after Config.Prefix, of the line sent every
+	// Config.SeriesCountInterval.
+	activeSeriesPath = "collector.active_series"
+
+	// Settings of the samples of dropped points emitted when
+	// Config.EmitDropSamples is enabled.
+	dropSamplePrefix   = "dropped."
+	dropReasonTagKey   = "drop_reason"
+	dropSampleInterval = time.Minute
+
+	// Default Carbon path separator, it can be changed via Config.Separator,
+	// and the token replacing it inside metric names when
+	// Config.EscapeSeparatorInName is enabled.
+	pathSeparator        = "."
+	pathSeparatorEscaped = "_"
+
 	// Textual representation for positive infinity valid in Carbon, ie.:
 	// positive infinity as represented in Python.
 	infinityCarbonValue = "inf"
 )
 
+// formatter converts internal metrics data to the Carbon plaintext format
+// honoring the settings of the exporter configuration.
+type formatter struct {
+	tagKeyCollisionPolicy string
+	maxPointAge           time.Duration
+	aggregationMethodTag  bool
+	escapeSeparatorInName bool
+	precisionByUnit       map[string]int
+	pipelineTag           string
+	resourceAsJSONTag     bool
+	resourceAttributeTags []string
+	kubernetesTags        bool
+	suppressZeros         []*regexp.Regexp
+	batchSentinel         string
+	deterministicOrder    bool
+	maxLinesPerExport     int
+	minNonZeroValue       float64
+	emitScopeMeta         bool
+	scopeVersionTag       bool
+	includeScope          bool
+	scopeAttributes       bool
+	// intervalAttribute names the attribute with the interval, in seconds,
+	// the timestamps of the data points are rounded down to.
+	intervalAttribute string
+	// metricTypes is nil when the metrics of all types are converted.
+	metricTypes     map[pmetric.MetricType]struct{}
+	monotonicSuffix string
+	geoTags         []tag
+	// tagsByMetric holds the Config.MetricTags by metric name.
+	tagsByMetric     map[string][]tag
+	retentionClasses []retentionClass
+	unicodePolicy    string
+	nanHandling      string
+	// defaultTemporality replaces the unspecified aggregation temporality.
+	defaultTemporality pmetric.AggregationTemporality
+	resourcelessPrefix string
+	// environmentInPath inserts the environment of the resources, or
+	// defaultEnvironment, as the first segment after pathPrefix.
+	environmentInPath  bool
+	defaultEnvironment string
+	// separator joins the segments of the paths built by the exporter.
+	separator string
+	// pathPrefix is prepended to the path of every metric, it is either
+	// empty or ends with separator.
+	pathPrefix      string
+	mergeHistograms bool
+	tagSeparator    string
+	tagKVSeparator  string
+	// dottedTags folds the tags into the path, sorted by key, instead of
+	// using the separators.
+	dottedTags bool
+	// When sanitizeNames is set the characters corrupting the lines are
+	// replaced by sanitizeReplacement in names and tags.
+	sanitizeNames       bool
+	sanitizeReplacement string
+	// separatorReplacer sanitizes non-default tag separators when
+	// sanitizeNames is set, it is nil otherwise.
+	separatorReplacer *strings.Replacer
+	// hashTagValues are the attributes whose values are written as their
+	// HMAC-SHA256 keyed with hashSalt.
+	hashTagValues map[string]struct{}
+	hashSalt      []byte
+	// tagKeyReplacer sanitizes non-default separators out of tag keys, it is
+	// nil when the default separators are used.
+	tagKeyReplacer *strings.Replacer
+	// dropSampler is nil when no samples of dropped points are emitted.
+	dropSampler *dropSampler
+	// dedup is nil when lines written by previous exports are not dropped.
+	dedup           *dedupCache
+	duplicatePolicy string
+	// movingAverages is nil when no moving averages of gauges are emitted.
+	movingAverages *movingAverages
+	// lastValues is nil when the last values of gauges are not repeated.
+	lastValues *lastValues
+	// cumulativeSums is nil when the cumulative monotonic sums are sent as
+	// they are, otherwise they are converted per sumConversion.
+	cumulativeSums *cumulativeSums
+	sumConversion  string
+	// activeSeries is nil when the active series are not counted.
+	activeSeries *activeSeries
+	// pathTemplate is nil when the metric names are written as they are.
+	pathTemplate *template.Template
+	// fieldOrder is nil when lines use the standard "<path> <value> <timestamp>" order.
+	fieldOrder *fieldOrder
+
+	telemetry *exporterTelemetry
+	clock     Clock
+}
+
+func newFormatter(cfg *Config, telemetry *exporterTelemetry, clock Clock) (*formatter, error) {
+	f := &formatter{
+		tagKeyCollisionPolicy: cfg.TagKeyCollisionPolicy,
+		maxPointAge:           cfg.MaxPointAge,
+		aggregationMethodTag:  cfg.AggregationMethodTag,
+		escapeSeparatorInName: cfg.EscapeSeparatorInName,
+		precisionByUnit:       cfg.PrecisionByUnit,
+		pipelineTag:           cfg.PipelineTag,
+		resourceAsJSONTag:     cfg.ResourceAttributesAsJSONTag,
+		resourceAttributeTags: cfg.ResourceAttributesAsTags,
+		kubernetesTags:        cfg.KubernetesTags,
+		batchSentinel:         cfg.BatchSentinel,
+		deterministicOrder:    cfg.DeterministicOrder,
+		maxLinesPerExport:     cfg.MaxLinesPerExport,
+		minNonZeroValue:       cfg.MinNonZeroValue,
+		emitScopeMeta:         cfg.EmitScopeMeta,
+		scopeVersionTag:       cfg.IncludeScopeVersionTag,
+		includeScope:          cfg.IncludeScope,
+		scopeAttributes:       cfg.IncludeScopeAttributes,
+		intervalAttribute:     cfg.IntervalAttribute,
+		tagsByMetric:          make(map[string][]tag, len(cfg.MetricTags)),
+		monotonicSuffix:       cfg.MonotonicSuffix,
+		unicodePolicy:         cfg.UnicodePolicy,
+		nanHandling:           cfg.NaNHandling,
+		defaultTemporality:    pmetric.AggregationTemporalityCumulative,
+		resourcelessPrefix:    cfg.ResourcelessPrefix,
+		environmentInPath:     cfg.EnvironmentInPath.Enabled,
+		defaultEnvironment:    cfg.EnvironmentInPath.Default,
+		mergeHistograms:       cfg.MergeHistogramFragments,
+		telemetry:             telemetry,
+		clock:                 clock,
+		tagSeparator:          cfg.TagSeparator,
+		tagKVSeparator:        cfg.TagKVSeparator,
+		separator:             cfg.Separator,
+		dottedTags:            cfg.MetricsFormat == metricsFormatDotted,
+		sanitizeNames:         cfg.SanitizeNames,
+		sanitizeReplacement:   cfg.SanitizeReplacement,
+	}
+	f.tagSeparator = stringOrDefault(f.tagSeparator, defaultTagSeparator)
+	f.tagKVSeparator = stringOrDefault(f.tagKVSeparator, defaultTagKVSeparator)
+	f.separator = stringOrDefault(f.separator, pathSeparator)
+	if len(cfg.HashTagValues) > 0 {
+		f.hashTagValues = make(map[string]struct{}, len(cfg.HashTagValues))
+		for _, key := range cfg.HashTagValues {
+			f.hashTagValues[key] = struct{}{}
+		}
+		f.hashSalt = []byte(cfg.HashTagValuesSalt)
+	}
+	if f.tagKVSeparator == "" {
+		f.tagKVSeparator = defaultTagKVSeparator
+	}
+	if cfg.DefaultTemporality == temporalityDelta {
+		f.defaultTemporality = pmetric.AggregationTemporalityDelta
+	}
+	if cfg.Prefix != "" {
+		f.pathPrefix = strings.TrimSuffix(cfg.Prefix, f.separator) + f.separator
+	}
+	if f.tagSeparator != defaultTagSeparator || f.tagKVSeparator != defaultTagKVSeparator {
+		f.tagKeyReplacer = strings.NewReplacer(
+			f.tagSeparator, string(sanitizedRune),
+			f.tagKVSeparator, string(sanitizedRune))
+	}
+	if f.sanitizeNames && !f.dottedTags {
+		var replacements []string
+		if f.tagSeparator != defaultTagSeparator {
+			replacements = append(replacements, f.tagSeparator, f.sanitizeReplacement)
+		}
+		if f.tagKVSeparator != defaultTagKVSeparator {
+			replacements = append(replacements, f.tagKVSeparator, f.sanitizeReplacement)
+		}
+		if len(replacements) > 0 {
+			f.separatorReplacer = strings.NewReplacer(replacements...)
+		}
+	}
+	for key, value := range cfg.GeoTags {
+		f.geoTags = append(f.geoTags, tag{key: key, value: value})
+	}
+	sort.Slice(f.geoTags, func(i, j int) bool {
+		return f.geoTags[i].key < f.geoTags[j].key
+	})
+	for name, metricTags := range cfg.MetricTags {
+		tags := make([]tag, 0, len(metricTags))
+		for key, value := range metricTags {
+			tags = append(tags, tag{key: key, value: value})
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i].key < tags[j].key
+		})
+		f.tagsByMetric[name] = tags
+	}
+	if cfg.EmitMovingAverage.Enabled {
+		f.movingAverages = newMovingAverages(cfg.EmitMovingAverage.Window, cfg.EmitMovingAverage.StalenessTTL, cfg.EmitMovingAverage.MaxSeries)
+	}
+	if cfg.RepeatLastValue.Enabled {
+		f.lastValues = newLastValues(cfg.RepeatLastValue.StalenessTTL, cfg.RepeatLastValue.MaxSeries)
+	}
+	if cfg.SumConversion.Mode == sumConversionDelta || cfg.SumConversion.Mode == sumConversionRate {
+		f.cumulativeSums = newCumulativeSums(cfg.SumConversion.StalenessTTL, cfg.SumConversion.MaxSeries)
+		f.sumConversion = cfg.SumConversion.Mode
+	}
+	if cfg.SeriesCountInterval > 0 {
+		f.activeSeries = newActiveSeries(cfg.SeriesCountMaxSeries)
+	}
+	if cfg.CrossExportDedupWindow > 0 {
+		f.dedup = newDedupCache(cfg.CrossExportDedupWindow, cfg.CrossExportDedupMaxEntries, cfg.ConcurrentDuplicatePolicy)
+		f.duplicatePolicy = cfg.ConcurrentDuplicatePolicy
+	}
+	if len(cfg.MetricTypes) > 0 {
+		f.metricTypes = make(map[pmetric.MetricType]struct{}, len(cfg.MetricTypes))
+		for _, metricType := range cfg.MetricTypes {
+			f.metricTypes[convertedMetricTypes[metricType]] = struct{}{}
+		}
+	}
+	if cfg.EmitDropSamples {
+		f.dropSampler = newDropSampler(dropSampleInterval)
+	}
+	if cfg.PathTemplate != "" {
+		tmpl, err := parsePathTemplate(cfg.PathTemplate)
+		if err != nil {
+			return nil, err
+		}
+		f.pathTemplate = tmpl
+	}
+	for _, class := range cfg.RetentionClasses {
+		re, err := compileMetricNamePattern(class.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.retentionClasses = append(f.retentionClasses, retentionClass{pattern: re, retention: class.Retention})
+	}
+	for _, pattern := range cfg.SuppressZeros {
+		re, err := compileMetricNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.suppressZeros = append(f.suppressZeros, re)
+	}
+	if cfg.FieldOrder != "" {
+		var err error
+		if f.fieldOrder, err = parseFieldOrder(cfg.FieldOrder); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// retentionClass tags the metrics whose name matches pattern with a
+// "retention" tag.
+type retentionClass struct {
+	pattern   *regexp.Regexp
+	retention string
+}
+
+// batch accumulates the Carbon lines generated from a single pmetric.Metrics
+// together with the accounting reported once the conversion is done.
+type batch struct {
+	buf        *bytes.Buffer
+	lines      int
+	fieldOrder *fieldOrder
+	// When sortLines is set lines are kept in pending until sortPending
+	// adds them in order.
+	sortLines     bool
+	pending       []pendingLine
+	maxLines      int
+	pointsByType  map[pmetric.MetricType]int
+	droppedPoints map[string]int
+	oldestAllowed pcommon.Timestamp
+	// pathTemplateFallbacks counts the data points named after their metric
+	// because the pathTemplate failed to render.
+	pathTemplateFallbacks int
+	// scopes holds the scopes already identified by a scope meta line.
+	scopes map[string]struct{}
+	// dedup is nil when lines written by previous exports are not dropped,
+	// otherwise dedupExport identifies the lines added to the batch and
+	// duplicatePolicy decides what happens to the lines already written.
+	dedup           *dedupCache
+	dedupNow        time.Time
+	dedupExport     dedupExport
+	duplicatePolicy string
+	// When trackPaths is set, for the active series count, paths holds the
+	// paths of the lines added to the batch.
+	trackPaths bool
+	paths      []string
+	// movingAverages is nil when no moving averages are emitted, otherwise it
+	// holds the gauge values of the batch by series, only added to the
+	// moving averages once the batch is written.
+	movingAverages map[string][]float64
+	// cumulativeSums is nil when the cumulative sums aren't converted,
+	// otherwise it holds the last point of each converted series, by path,
+	// recorded as their previous point once the batch is written.
+	cumulativeSums map[string]cumulativePoint
+}
+
+// exportState holds the changes of an export to the state kept across
+// exports, applied by recordWritten once the export is written and dropped
+// by forgetUnwritten if it fails, so its retry doesn't apply them twice.
+type exportState struct {
+	dedup          dedupExport
+	movingAverages map[string][]float64
+	cumulativeSums map[string]cumulativePoint
+}
+
+func (f *formatter) newBatch(buf *bytes.Buffer) *batch {
+	b := &batch{
+		buf:             buf,
+		fieldOrder:      f.fieldOrder,
+		sortLines:       f.deterministicOrder,
+		maxLines:        f.maxLinesPerExport,
+		pointsByType:    make(map[pmetric.MetricType]int),
+		droppedPoints:   make(map[string]int),
+		scopes:          make(map[string]struct{}),
+		dedup:           f.dedup,
+		duplicatePolicy: f.duplicatePolicy,
+		trackPaths:      f.activeSeries != nil,
+	}
+	if f.dedup != nil {
+		b.dedupNow = f.clock.Now()
+		b.dedupExport.id = f.dedup.newExport()
+	}
+	if f.movingAverages != nil {
+		b.movingAverages = make(map[string][]float64)
+	}
+	if f.cumulativeSums != nil {
+		b.cumulativeSums = make(map[string]cumulativePoint)
+	}
+	if f.maxPointAge > 0 {
+		b.oldestAllowed = pcommon.NewTimestampFromTime(f.clock.Now().Add(-f.maxPointAge))
+	}
+	return b
+}
+
+// pendingLine is a line kept, with its fields, until it is added in order.
+type pendingLine struct {
+	line                   string
+	path, value, timestamp string
+}
+
+// addLine adds a line to the batch, dropping it once the batch holds
+// maxLines lines or if it was written by a previous export within the dedup
+// window.
+func (b *batch) addLine(path, value, timestamp string) {
+	if b.sortLines {
+		// The cap and the dedup apply once the lines are sorted, so only the
+		// lines kept are recorded.
+		b.pending = append(b.pending, pendingLine{
+			line:      b.formatLine(path, value, timestamp),
+			path:      path,
+			value:     value,
+			timestamp: timestamp,
+		})
+		return
+	}
+	if b.maxLines > 0 && b.lines >= b.maxLines {
+		b.droppedPoints[dropReasonMaxLines]++
+		return
+	}
+	if b.dedup != nil {
+		key := path + " " + timestamp
+		var ok bool
+		if value, ok = b.resolveDuplicate(key, value); !ok {
+			b.droppedPoints[dropReasonDuplicate]++
+			return
+		}
+		b.dedupExport.keys = append(b.dedupExport.keys, key)
+	}
+	if b.trackPaths {
+		b.paths = append(b.paths, path)
+	}
+	b.lines++
+	b.buf.WriteString(b.formatLine(path, value, timestamp))
+}
+
+// resolveDuplicate returns the value to write for the line with the given key
+// and false if it was already written and must be dropped per the
+// duplicatePolicy.
+func (b *batch) resolveDuplicate(key, value string) (string, bool) {
+	if b.duplicatePolicy != "" && b.duplicatePolicy != duplicatePolicyDrop {
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			resolved, ok := b.dedup.resolve(key, v, b.dedupExport.id, b.dedupNow)
+			if !ok || resolved == v {
+				return value, ok
+			}
+			return formatFloatForValue(resolved), true
+		}
+	}
+	return value, !b.dedup.seen(key, b.dedupNow)
+}
+
+func (b *batch) formatLine(path, value, timestamp string) string {
+	if b.fieldOrder != nil {
+		return b.fieldOrder.buildLine(path, value, timestamp)
+	}
+	return buildLine(path, value, timestamp)
+}
+
+// sortPending adds the pending lines sorted, later lines are added as they
+// come. Only the first maxLines sorted lines, once the duplicates are
+// dropped, are kept.
+func (b *batch) sortPending() {
+	sort.Slice(b.pending, func(i, j int) bool {
+		return b.pending[i].line < b.pending[j].line
+	})
+	b.sortLines = false
+	for _, p := range b.pending {
+		b.addLine(p.path, p.value, p.timestamp)
+	}
+	b.pending = nil
+}
+
+// accept reports if a data point with the given timestamp should be converted,
+// dropping it otherwise. The line function returns the path and value of the
+// point, it is only called if a sample of the dropped point is emitted.
+func (f *formatter) accept(b *batch, timestamp pcommon.Timestamp, line func() (path, value string)) bool {
+	if timestamp < b.oldestAllowed {
+		f.drop(b, dropReasonTooOld, line)
+		return false
+	}
+	return true
+}
+
+// drop accounts for a data point dropped for the given reason. When drop
+// samples are enabled the line of the point is also emitted, prefixed with
+// dropSamplePrefix and tagged with the reason, at most once per reason every
+// dropSampleInterval.
+func (f *formatter) drop(b *batch, reason string, line func() (path, value string)) {
+	b.droppedPoints[reason]++
+	if f.dropSampler == nil {
+		return
+	}
+	now := f.clock.Now()
+	if !f.dropSampler.due(reason, now) {
+		return
+	}
+	path, value := line()
+	b.addLine(
+		f.generatedPath(dropSamplePrefix)+strings.TrimPrefix(path, f.pathPrefix)+f.formatTag(dropReasonTagKey, reason),
+		value,
+		formatTimestamp(pcommon.NewTimestampFromTime(now)))
+}
+
+// dropSampler rate limits the samples of dropped points per drop reason, it
+// is shared by all the exports of the exporter.
+type dropSampler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newDropSampler(interval time.Duration) *dropSampler {
+	return &dropSampler{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// due reports if a sample for the reason should be emitted at now, recording
+// it as the last sample if so.
+func (s *dropSampler) due(reason string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.last[reason]; ok && now.Sub(last) < s.interval {
+		return false
+	}
+	s.last[reason] = now
+	return true
+}
+
 // metricDataToPlaintext converts internal metrics data to the Carbon plaintext
 // format as defined in https://graphite.readthedocs.io/en/latest/feeding-carbon.html#the-plaintext-protocol)
 // and https://graphite.readthedocs.io/en/latest/tags.html#carbon. See details
@@ -63,59 +601,343 @@ const (
 //
 // The <timestamp> is the Unix time text of when the measurement was made.
 //
+// Every line, including the last one, is terminated by a new-line character so
+// receivers never see an incomplete final line of a batch.
+//
 // The returned values are:
 //   - a string concatenating all generated "lines" (each single one representing
 //     a single Carbon metric.
 //   - number of time series successfully converted to carbon.
 //   - number of time series that could not be converted to Carbon.
-func metricDataToPlaintext(md pmetric.Metrics) string {
+func (f *formatter) metricDataToPlaintext(ctx context.Context, md pmetric.Metrics) string {
+	var buf bytes.Buffer
+	f.writePlaintext(ctx, &buf, md)
+	return buf.String()
+}
+
+// writePlaintext appends the lines described in metricDataToPlaintext to buf.
+// It returns the state of the export to pass to recordWritten once the lines
+// are written, or to forgetUnwritten if they can't be.
+func (f *formatter) writePlaintext(ctx context.Context, buf *bytes.Buffer, md pmetric.Metrics) exportState {
 	if md.DataPointCount() == 0 {
-		return ""
+		return exportState{}
 	}
 
-	var sb strings.Builder
+	b := f.newBatch(buf)
+
+	// The metrics of each retention class are formatted after the others,
+	// grouping their lines per class in the order the classes are configured.
+	var classified [][]classifiedMetric
+	if len(f.retentionClasses) > 0 {
+		classified = make([][]classifiedMetric, len(f.retentionClasses))
+	}
 
 	for i := 0; i < md.ResourceMetrics().Len(); i++ {
 		rm := md.ResourceMetrics().At(i)
+		resourceTags := f.resourceTags(rm.Resource())
+		namePrefix := f.pathPrefix
+		if f.environmentInPath {
+			if env := f.environment(rm.Resource()); env != "" {
+				namePrefix += env + f.separator
+			}
+		}
+		if f.resourcelessPrefix != "" && rm.Resource().Attributes().Len() == 0 {
+			namePrefix += f.resourcelessPrefix + f.separator
+		}
 		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
 			sm := rm.ScopeMetrics().At(j)
+			if f.emitScopeMeta && sm.Metrics().Len() > 0 {
+				f.addScopeMeta(b, sm.Scope())
+			}
+			var scopeTags []tag
+			if f.scopeVersionTag && sm.Scope().Version() != "" {
+				scopeTags = []tag{{key: scopeVersionTagKey, value: sm.Scope().Version()}}
+			}
+			if f.scopeAttributes {
+				for _, t := range f.attributeTags(sm.Scope().Attributes()) {
+					t.scopeAttribute = true
+					scopeTags = append(scopeTags, t)
+				}
+			}
+			scopePrefix := namePrefix
+			if f.includeScope && sm.Scope().Name() != "" {
+				scopePrefix += sanitizePathNode(sm.Scope().Name(), f.separator) + f.separator
+			}
 			for k := 0; k < sm.Metrics().Len(); k++ {
 				metric := sm.Metrics().At(k)
 				if metric.Name() == "" {
 					// TODO: log error info
 					continue
 				}
-				switch metric.Type() {
-				case pmetric.MetricTypeGauge:
-					formatNumberDataPoints(&sb, metric.Name(), metric.Gauge().DataPoints())
-				case pmetric.MetricTypeSum:
-					formatNumberDataPoints(&sb, metric.Name(), metric.Sum().DataPoints())
-				case pmetric.MetricTypeHistogram:
-					formatHistogramDataPoints(&sb, metric.Name(), metric.Histogram().DataPoints())
-				case pmetric.MetricTypeSummary:
-					formatSummaryDataPoints(&sb, metric.Name(), metric.Summary().DataPoints())
+				if !f.convertsType(metric.Type()) {
+					continue
+				}
+				metrics, names := []pmetric.Metric{metric}, []string{f.metricName(metric)}
+				if f.pathTemplate != nil {
+					metrics, names = f.splitByPath(b, metric, rm.Resource().Attributes(), sm.Scope())
+				}
+				for l, m := range metrics {
+					name := scopePrefix + names[l]
+					tags := append(append(f.metricTags(m), resourceTags...), scopeTags...)
+					if class := f.retentionClass(m); class >= 0 {
+						tags = append(tags, tag{key: retentionTagKey, value: f.retentionClasses[class].retention})
+						classified[class] = append(classified[class], classifiedMetric{metric: m, name: name, tags: tags})
+						continue
+					}
+					f.formatMetric(b, m, name, tags)
 				}
 			}
 		}
 	}
 
-	return sb.String()
+	for _, metrics := range classified {
+		for _, cm := range metrics {
+			f.formatMetric(b, cm.metric, cm.name, cm.tags)
+		}
+	}
+
+	if b.sortLines {
+		b.sortPending()
+	}
+
+	if f.batchSentinel != "" && b.lines > 0 {
+		// The sentinel closes the batch carrying the number of data points
+		// converted into the lines before it, histograms and summaries
+		// counting once whatever their number of lines. It is not subject to
+		// maxLines.
+		points := 0
+		for _, n := range b.pointsByType {
+			points += n
+		}
+		b.buf.WriteString(b.formatLine(f.pathPrefix+f.batchSentinel, strconv.Itoa(points), formatTimestamp(pcommon.NewTimestampFromTime(f.clock.Now()))))
+	}
+
+	if f.activeSeries != nil {
+		f.activeSeries.add(b.paths)
+	}
+
+	f.telemetry.recordPointsByType(ctx, b.pointsByType)
+	f.telemetry.recordDroppedPoints(ctx, b.droppedPoints)
+	f.telemetry.recordPathTemplateFallbacks(ctx, b.pathTemplateFallbacks)
+	return exportState{dedup: b.dedupExport, movingAverages: b.movingAverages, cumulativeSums: b.cumulativeSums}
+}
+
+// recordWritten applies the state of the export returned by writePlaintext
+// once its lines are written: later exports drop or resolve them within the
+// dedup window, its gauge values join the moving averages and its cumulative
+// sums become the previous points of their series.
+func (f *formatter) recordWritten(export exportState) {
+	now := f.clock.Now()
+	if f.dedup != nil && len(export.dedup.keys) > 0 {
+		f.dedup.record(export.dedup, now)
+	}
+	if f.movingAverages != nil && len(export.movingAverages) > 0 {
+		f.movingAverages.record(export.movingAverages, now)
+	}
+	if f.cumulativeSums != nil && len(export.cumulativeSums) > 0 {
+		f.cumulativeSums.record(export.cumulativeSums, now)
+	}
+}
+
+// forgetUnwritten drops the state of the export returned by writePlaintext,
+// which failed, so its retry starts over from the state of the exports
+// written.
+func (f *formatter) forgetUnwritten(export exportState) {
+	if f.dedup != nil && len(export.dedup.keys) > 0 {
+		f.dedup.forget(export.dedup)
+	}
+}
+
+func (f *formatter) formatNumberDataPoints(
+	b *batch,
+	metricName string,
+	metricTags []tag,
+	precision int,
+	suppressZeros bool,
+	dps pmetric.NumberDataPointSlice,
+) {
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		line := func() (string, string) {
+			return f.buildPath(metricName, dp.Attributes(), metricTags), f.formatNumberValue(dp, precision)
+		}
+		if !f.accept(b, dp.Timestamp(), line) {
+			continue
+		}
+		if suppressZeros && isZero(dp) {
+			f.drop(b, dropReasonZeroValue, line)
+			continue
+		}
+		path, valueStr := line()
+		if dp.ValueType() == pmetric.NumberDataPointValueTypeDouble && !isFinite(dp.DoubleValue()) {
+			value, ok := f.applyNaNHandling(dp.DoubleValue())
+			if !ok {
+				f.drop(b, dropReasonNonFinite, line)
+				continue
+			}
+			valueStr = f.formatFloat(value, precision)
+		}
+		b.addLine(path, valueStr, f.pointTimestamp(dp.Timestamp(), dp.Attributes()))
+	}
 }
 
-func formatNumberDataPoints(sb *strings.Builder, metricName string, dps pmetric.NumberDataPointSlice) {
+// convertsSum reports whether the metric is a cumulative monotonic sum
+// converted per sumConversion.
+func (f *formatter) convertsSum(metric pmetric.Metric) bool {
+	return f.cumulativeSums != nil &&
+		metric.Type() == pmetric.MetricTypeSum &&
+		metric.Sum().IsMonotonic() &&
+		f.temporality(metric.Sum().AggregationTemporality()) == pmetric.AggregationTemporalityCumulative
+}
+
+// formatConvertedSums adds a line per data point with the increase of its
+// series since the previous point, or that increase per second with the
+// "rate" sumConversion. The points without a previous point to compare with
+// are not sent.
+func (f *formatter) formatConvertedSums(
+	b *batch,
+	metricName string,
+	metricTags []tag,
+	precision int,
+	suppressZeros bool,
+	dps pmetric.NumberDataPointSlice,
+) {
+	now := f.clock.Now()
 	for i := 0; i < dps.Len(); i++ {
 		dp := dps.At(i)
-		var valueStr string
+		var value float64
 		switch dp.ValueType() {
 		case pmetric.NumberDataPointValueTypeInt:
-			valueStr = formatInt64(dp.IntValue())
+			value = float64(dp.IntValue())
 		case pmetric.NumberDataPointValueTypeDouble:
-			valueStr = formatFloatForValue(dp.DoubleValue())
+			value = dp.DoubleValue()
+		default:
+			continue
+		}
+		path := f.buildPath(metricName, dp.Attributes(), metricTags)
+		line := func() (string, string) {
+			return path, f.formatNumberValue(dp, precision)
+		}
+		if !f.accept(b, dp.Timestamp(), line) {
+			continue
+		}
+		if !isFinite(value) {
+			f.drop(b, dropReasonNonFinite, line)
+			continue
 		}
-		sb.WriteString(buildLine(buildPath(metricName, dp.Attributes()), valueStr, formatTimestamp(dp.Timestamp())))
+		delta, elapsed, ok := f.cumulativeSums.delta(path, dp.StartTimestamp(), dp.Timestamp(), value, b.cumulativeSums, now)
+		if !ok {
+			continue
+		}
+		if f.sumConversion == sumConversionRate {
+			delta /= elapsed.Seconds()
+		}
+		if suppressZeros && delta == 0 {
+			f.drop(b, dropReasonZeroValue, func() (string, string) {
+				return path, f.formatFloat(delta, precision)
+			})
+			continue
+		}
+		b.addLine(path, f.formatFloat(delta, precision), f.pointTimestamp(dp.Timestamp(), dp.Attributes()))
 	}
 }
 
+// applyNaNHandling handles the NaN and infinite values per nanHandling, it
+// returns the value to send and whether to send it at all.
+func (f *formatter) applyNaNHandling(v float64) (float64, bool) {
+	if isFinite(v) {
+		return v, true
+	}
+	switch f.nanHandling {
+	case nanHandlingPassthrough:
+		return v, true
+	case nanHandlingZero:
+		return 0, true
+	}
+	return 0, false
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// formatMovingAverages adds a "<metricName>.avg" line per data point with the
+// moving average of the series of the point, including it. Points too old
+// to be sent don't update the average.
+func (f *formatter) formatMovingAverages(
+	b *batch,
+	metricName string,
+	metricTags []tag,
+	precision int,
+	dps pmetric.NumberDataPointSlice,
+) {
+	now := f.clock.Now()
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		if dp.Timestamp() < b.oldestAllowed {
+			continue
+		}
+		var value float64
+		switch dp.ValueType() {
+		case pmetric.NumberDataPointValueTypeInt:
+			value = float64(dp.IntValue())
+		case pmetric.NumberDataPointValueTypeDouble:
+			var ok bool
+			if value, ok = f.applyNaNHandling(dp.DoubleValue()); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+		key := f.buildPath(metricName, dp.Attributes(), metricTags)
+		b.movingAverages[key] = append(b.movingAverages[key], value)
+		avg := f.movingAverages.average(key, b.movingAverages[key], now)
+		b.addLine(
+			f.buildPath(metricName+f.joinPath(movingAverageSuffix), dp.Attributes(), metricTags),
+			f.formatFloat(avg, precision),
+			f.pointTimestamp(dp.Timestamp(), dp.Attributes()))
+	}
+}
+
+// updateLastValues remembers the value of each data point as the last one of
+// its series, to be repeated by repeatedLines. Points too old to be sent are
+// ignored.
+func (f *formatter) updateLastValues(
+	b *batch,
+	metricName string,
+	metricTags []tag,
+	precision int,
+	dps pmetric.NumberDataPointSlice,
+) {
+	now := f.clock.Now()
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		if dp.Timestamp() < b.oldestAllowed || dp.ValueType() == pmetric.NumberDataPointValueTypeEmpty {
+			continue
+		}
+		valueStr := f.formatNumberValue(dp, precision)
+		if dp.ValueType() == pmetric.NumberDataPointValueTypeDouble {
+			value, ok := f.applyNaNHandling(dp.DoubleValue())
+			if !ok {
+				continue
+			}
+			valueStr = f.formatFloat(value, precision)
+		}
+		f.lastValues.update(f.buildPath(metricName, dp.Attributes(), metricTags), valueStr, now)
+	}
+}
+
+// repeatedLines returns the lines, timestamped now, repeating the last value
+// of the gauge series not updated within the last interval, and their count.
+func (f *formatter) repeatedLines(interval time.Duration) (string, int) {
+	due := f.lastValues.due(f.clock.Now(), interval)
+	var sb strings.Builder
+	for _, v := range due {
+		sb.WriteString(f.lineAtNow(v.path, v.value))
+	}
+	return sb.String(), len(due)
+}
+
 // formatHistogramDataPoints transforms a slice of histogram data points into a series
 // of Carbon metrics and injects them into the string builder.
 //
@@ -130,16 +952,24 @@ func formatNumberDataPoints(sb *strings.Builder, metricName string, dps pmetric.
 // and will include a dimension "upper_bound" that specifies the maximum value in
 // that bucket. This metric specifies the number of events with a value that is
 // less than or equal to the upper bound.
-func formatHistogramDataPoints(
-	sb *strings.Builder,
+func (f *formatter) formatHistogramDataPoints(
+	b *batch,
 	metricName string,
+	metricTags []tag,
+	precision int,
 	dps pmetric.HistogramDataPointSlice,
 ) {
 	for i := 0; i < dps.Len(); i++ {
 		dp := dps.At(i)
+		countLine := func() (string, string) {
+			return f.buildPath(metricName+f.joinPath(countSuffix), dp.Attributes(), metricTags), formatUint64(dp.Count())
+		}
+		if !f.accept(b, dp.Timestamp(), countLine) {
+			continue
+		}
 
-		timestampStr := formatTimestamp(dp.Timestamp())
-		formatCountAndSum(sb, metricName, dp.Attributes(), dp.Count(), dp.Sum(), timestampStr)
+		timestampStr := f.pointTimestamp(dp.Timestamp(), dp.Attributes())
+		f.formatCountAndSum(b, metricName, dp.Attributes(), metricTags, precision, dp.Count(), dp.Sum(), timestampStr)
 		if dp.ExplicitBounds().Len() == 0 {
 			continue
 		}
@@ -151,13 +981,94 @@ func formatHistogramDataPoints(
 		}
 		carbonBounds[len(carbonBounds)-1] = infinityCarbonValue
 
-		bucketPath := buildPath(metricName+distributionBucketSuffix, dp.Attributes())
+		bucketPath := f.buildPath(metricName+f.joinPath(distributionBucketSuffix), dp.Attributes(), metricTags)
 		for j := 0; j < dp.BucketCounts().Len(); j++ {
-			sb.WriteString(buildLine(bucketPath+distributionUpperBoundTagBeforeValue+carbonBounds[j], formatUint64(dp.BucketCounts().At(j)), timestampStr))
+			b.addLine(bucketPath+f.formatTag(distributionUpperBoundTagKey, carbonBounds[j]), formatUint64(dp.BucketCounts().At(j)), timestampStr)
 		}
 	}
 }
 
+// mergeHistogramFragments returns the data points with the fragments of the
+// same series merged into the first one of them. Data points are fragments of
+// the same series when they have the same attributes and timestamps, and
+// either the same explicit bounds or no bucket counts at all. The data points
+// are left untouched, the merged ones are copies.
+func mergeHistogramFragments(dps pmetric.HistogramDataPointSlice) pmetric.HistogramDataPointSlice {
+	if dps.Len() < 2 {
+		return dps
+	}
+
+	merged := pmetric.NewHistogramDataPointSlice()
+	merged.EnsureCapacity(dps.Len())
+	series := make(map[string][]int, dps.Len())
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		key := histogramSeriesKey(dp)
+		fragment := false
+		for _, j := range series[key] {
+			if mergeHistogramDataPoint(merged.At(j), dp) {
+				fragment = true
+				break
+			}
+		}
+		if !fragment {
+			series[key] = append(series[key], merged.Len())
+			dp.CopyTo(merged.AppendEmpty())
+		}
+	}
+	return merged
+}
+
+// histogramSeriesKey identifies the series of a histogram data point by its
+// timestamps and attributes.
+func histogramSeriesKey(dp pmetric.HistogramDataPoint) string {
+	// The map keys are sorted when encoded, so equal attributes give the same key.
+	attributes, _ := json.Marshal(dp.Attributes().AsRaw())
+	return formatTimestamp(dp.StartTimestamp()) + " " + formatTimestamp(dp.Timestamp()) + " " + string(attributes)
+}
+
+// mergeHistogramDataPoint adds src to dst if their buckets are compatible,
+// reporting whether it did.
+func mergeHistogramDataPoint(dst, src pmetric.HistogramDataPoint) bool {
+	dstBuckets, srcBuckets := dst.BucketCounts(), src.BucketCounts()
+	switch {
+	case srcBuckets.Len() == 0:
+	case dstBuckets.Len() == 0:
+		srcBuckets.CopyTo(dstBuckets)
+		src.ExplicitBounds().CopyTo(dst.ExplicitBounds())
+	case dstBuckets.Len() == srcBuckets.Len() && equalBounds(dst.ExplicitBounds(), src.ExplicitBounds()):
+		for i := 0; i < srcBuckets.Len(); i++ {
+			dstBuckets.SetAt(i, dstBuckets.At(i)+srcBuckets.At(i))
+		}
+	default:
+		return false
+	}
+
+	dst.SetCount(dst.Count() + src.Count())
+	if src.HasSum() {
+		dst.SetSum(dst.Sum() + src.Sum())
+	}
+	if src.HasMin() && (!dst.HasMin() || src.Min() < dst.Min()) {
+		dst.SetMin(src.Min())
+	}
+	if src.HasMax() && (!dst.HasMax() || src.Max() > dst.Max()) {
+		dst.SetMax(src.Max())
+	}
+	return true
+}
+
+func equalBounds(a, b pcommon.Float64Slice) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for i := 0; i < a.Len(); i++ {
+		if a.At(i) != b.At(i) {
+			return false
+		}
+	}
+	return true
+}
+
 // formatSummaryDataPoints transforms a slice of summary data points into a series
 // of Carbon metrics and injects them into the string builder.
 //
@@ -170,27 +1081,41 @@ func formatHistogramDataPoints(
 //
 // 3. Each quantile is represented by a metric named "<metricName>.quantile"
 // and will include a tag key "quantile" that specifies the quantile value.
-func formatSummaryDataPoints(
-	sb *strings.Builder,
+// The quantiles whose value is NaN, e.g. computed over no values, are skipped
+// as Graphite can't store it.
+func (f *formatter) formatSummaryDataPoints(
+	b *batch,
 	metricName string,
+	metricTags []tag,
+	precision int,
 	dps pmetric.SummaryDataPointSlice,
 ) {
 	for i := 0; i < dps.Len(); i++ {
 		dp := dps.At(i)
+		countLine := func() (string, string) {
+			return f.buildPath(metricName+f.joinPath(countSuffix), dp.Attributes(), metricTags), formatUint64(dp.Count())
+		}
+		if !f.accept(b, dp.Timestamp(), countLine) {
+			continue
+		}
 
-		timestampStr := formatTimestamp(dp.Timestamp())
-		formatCountAndSum(sb, metricName, dp.Attributes(), dp.Count(), dp.Sum(), timestampStr)
+		timestampStr := f.pointTimestamp(dp.Timestamp(), dp.Attributes())
+		f.formatCountAndSum(b, metricName, dp.Attributes(), metricTags, precision, dp.Count(), dp.Sum(), timestampStr)
 
 		if dp.QuantileValues().Len() == 0 {
 			continue
 		}
 
-		quantilePath := buildPath(metricName+summaryQuantileSuffix, dp.Attributes())
+		quantilePath := f.buildPath(metricName+f.joinPath(summaryQuantileSuffix), dp.Attributes(), metricTags)
 		for j := 0; j < dp.QuantileValues().Len(); j++ {
-			sb.WriteString(buildLine(
-				quantilePath+summaryQuantileTagBeforeValue+formatFloatForLabel(dp.QuantileValues().At(j).Quantile()*100),
-				formatFloatForValue(dp.QuantileValues().At(j).Value()),
-				timestampStr))
+			quantile := dp.QuantileValues().At(j)
+			if math.IsNaN(quantile.Value()) {
+				continue
+			}
+			b.addLine(
+				quantilePath+f.formatTag(summaryQuantileTagKey, formatFloatForLabel(quantile.Quantile()*100)),
+				f.formatFloat(quantile.Value(), precision),
+				timestampStr)
 		}
 	}
 }
@@ -202,43 +1127,584 @@ func formatSummaryDataPoints(
 // 1. The total count will be represented by a metric named "<metricName>.count".
 //
 // 2. The total sum will be represented by a metruc with the original "<metricName>".
-func formatCountAndSum(
-	sb *strings.Builder,
+func (f *formatter) formatCountAndSum(
+	b *batch,
 	metricName string,
 	attributes pcommon.Map,
+	metricTags []tag,
+	precision int,
 	count uint64,
 	sum float64,
 	timestampStr string,
 ) {
 	// Build count and sum metrics.
-	countPath := buildPath(metricName+countSuffix, attributes)
+	countPath := f.buildPath(metricName+f.joinPath(countSuffix), attributes, metricTags)
 	valueStr := formatUint64(count)
-	sb.WriteString(buildLine(countPath, valueStr, timestampStr))
+	b.addLine(countPath, valueStr, timestampStr)
+
+	sumPath := f.buildPath(metricName, attributes, metricTags)
+	valueStr = f.formatFloat(sum, precision)
+	b.addLine(sumPath, valueStr, timestampStr)
+}
+
+// classifiedMetric is a metric of a retention class waiting to be formatted.
+type classifiedMetric struct {
+	metric pmetric.Metric
+	name   string
+	tags   []tag
+}
+
+// formatMetric adds the lines of the data points of the metric to the batch,
+// each one with the given name and tags.
+func (f *formatter) formatMetric(b *batch, metric pmetric.Metric, metricName string, tags []tag) {
+	precision := f.precision(metric)
+	switch metric.Type() {
+	case pmetric.MetricTypeGauge:
+		b.pointsByType[metric.Type()] += metric.Gauge().DataPoints().Len()
+		f.formatNumberDataPoints(b, metricName, tags, precision, f.suppressesZeros(metric), metric.Gauge().DataPoints())
+		if f.movingAverages != nil {
+			f.formatMovingAverages(b, metricName, tags, precision, metric.Gauge().DataPoints())
+		}
+		if f.lastValues != nil {
+			f.updateLastValues(b, metricName, tags, precision, metric.Gauge().DataPoints())
+		}
+	case pmetric.MetricTypeSum:
+		b.pointsByType[metric.Type()] += metric.Sum().DataPoints().Len()
+		if f.convertsSum(metric) {
+			f.formatConvertedSums(b, metricName, tags, precision, f.suppressesZeros(metric), metric.Sum().DataPoints())
+			return
+		}
+		f.formatNumberDataPoints(b, metricName, tags, precision, f.suppressesZeros(metric), metric.Sum().DataPoints())
+	case pmetric.MetricTypeHistogram:
+		dps := metric.Histogram().DataPoints()
+		b.pointsByType[metric.Type()] += dps.Len()
+		if f.mergeHistograms {
+			dps = mergeHistogramFragments(dps)
+		}
+		f.formatHistogramDataPoints(b, metricName, tags, precision, dps)
+	case pmetric.MetricTypeSummary:
+		b.pointsByType[metric.Type()] += metric.Summary().DataPoints().Len()
+		f.formatSummaryDataPoints(b, metricName, tags, precision, metric.Summary().DataPoints())
+	}
+}
+
+// convertedMetricTypes holds the types of the metrics converted to Carbon
+// lines by their name in Config.MetricTypes.
+var convertedMetricTypes = map[string]pmetric.MetricType{
+	"gauge":     pmetric.MetricTypeGauge,
+	"sum":       pmetric.MetricTypeSum,
+	"histogram": pmetric.MetricTypeHistogram,
+	"summary":   pmetric.MetricTypeSummary,
+}
+
+// convertsType reports whether the metrics of the given type are converted
+// per Config.MetricTypes.
+func (f *formatter) convertsType(metricType pmetric.MetricType) bool {
+	if f.metricTypes == nil {
+		return true
+	}
+	_, ok := f.metricTypes[metricType]
+	return ok
+}
+
+// retentionClass returns the index of the first retention class matching the
+// name of the metric, -1 if none does.
+func (f *formatter) retentionClass(metric pmetric.Metric) int {
+	for i, class := range f.retentionClasses {
+		if class.pattern.MatchString(metric.Name()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addScopeMeta adds a line identifying the instrumentation scope, by name and
+// version, once per scope per batch.
+func (f *formatter) addScopeMeta(b *batch, scope pcommon.InstrumentationScope) {
+	key := scope.Name() + "\x00" + scope.Version()
+	if _, ok := b.scopes[key]; ok {
+		return
+	}
+	b.scopes[key] = struct{}{}
+
+	path := f.buildPath(f.generatedPath(scopeMetaPath), pcommon.NewMap(), []tag{
+		{key: scopeMetaNameTagKey, value: sanitizeTagValue(scope.Name())},
+		{key: scopeMetaVersionTagKey, value: sanitizeTagValue(scope.Version())},
+	})
+	b.addLine(path, "1", formatTimestamp(pcommon.NewTimestampFromTime(f.clock.Now())))
+}
+
+// metricName returns the name used as the base of the Carbon path of the
+// metric. When escapeSeparatorInName is enabled, separators between two digits,
+// as in a version like "v1.2", are replaced so they don't split the name into
+// unintended path components. The monotonicSuffix is appended to the name of
+// monotonic sums.
+func (f *formatter) metricName(metric pmetric.Metric) string {
+	name := f.sanitizeName(f.applyUnicodePolicy(metric.Name()))
+	if f.escapeSeparatorInName && strings.Contains(name, f.separator) {
+		name = escapeSeparatorBetweenDigits(name, f.separator)
+	}
+	if f.monotonicSuffix != "" && metric.Type() == pmetric.MetricTypeSum && metric.Sum().IsMonotonic() {
+		name += f.monotonicSuffix
+	}
+	return name
+}
+
+// escapeSeparatorBetweenDigits replaces the separators of name found between
+// two digits with pathSeparatorEscaped.
+func escapeSeparatorBetweenDigits(name, separator string) string {
+	var sb strings.Builder
+	sb.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		end := i + len(separator)
+		if strings.HasPrefix(name[i:], separator) && i > 0 && end < len(name) && isDigit(name[i-1]) && isDigit(name[end]) {
+			sb.WriteString(pathSeparatorEscaped)
+			i = end - 1
+			continue
+		}
+		sb.WriteByte(name[i])
+	}
+	return sb.String()
+}
+
+// applyUnicodePolicy handles the non-ASCII characters of s per the configured
+// unicodePolicy.
+func (f *formatter) applyUnicodePolicy(s string) string {
+	if f.unicodePolicy == "" || f.unicodePolicy == unicodePolicyKeep || isASCII(s) {
+		return s
+	}
+	if f.unicodePolicy == unicodePolicyASCIITransliterate {
+		// The decomposition splits accented letters into their base letter
+		// followed by combining marks, which are removed below.
+		s = norm.NFD.String(s)
+	}
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeName replaces the characters of s that would corrupt the line, see
+// isLineControlRune, and the non-default tag separators with
+// sanitizeReplacement when sanitizeNames is set.
+func (f *formatter) sanitizeName(s string) string {
+	if !f.sanitizeNames {
+		return s
+	}
+	if f.separatorReplacer != nil {
+		s = f.separatorReplacer.Replace(s)
+	}
+	if strings.IndexFunc(s, isLineControlRune) < 0 {
+		return s
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if isLineControlRune(r) {
+			sb.WriteString(f.sanitizeReplacement)
+		} else {
+			sb.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// isLineControlRune reports whether r separates the fields or the lines, the
+// whitespaces, or the tags, ";" and "=".
+func isLineControlRune(r rune) bool {
+	return r == ';' || r == '=' || unicode.IsSpace(r)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// precision returns the number of decimals used to format the floating point
+// values of the metric, -1 when no precision is configured for its unit.
+func (f *formatter) precision(metric pmetric.Metric) int {
+	if precision, ok := f.precisionByUnit[metric.Unit()]; ok {
+		return precision
+	}
+	return -1
+}
+
+// suppressesZeros reports if zero valued data points of the metric must be
+// dropped per the configured suppressZeros patterns.
+func (f *formatter) suppressesZeros(metric pmetric.Metric) bool {
+	for _, re := range f.suppressZeros {
+		if re.MatchString(metric.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileMetricNamePattern compiles a regular expression that must match the
+// whole metric name.
+func compileMetricNamePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+func (f *formatter) formatNumberValue(dp pmetric.NumberDataPoint, precision int) string {
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		return formatInt64(dp.IntValue())
+	case pmetric.NumberDataPointValueTypeDouble:
+		return f.formatFloat(dp.DoubleValue(), precision)
+	}
+	return ""
+}
+
+// formatFloat formats a floating point value with the given precision, see
+// formatFloatWithPrecision, after rounding the non-zero values closer to zero
+// than minNonZeroValue to either zero or the minimum, whichever is nearest.
+func (f *formatter) formatFloat(v float64, precision int) string {
+	if f.minNonZeroValue > 0 && v != 0 && math.Abs(v) < f.minNonZeroValue {
+		switch {
+		case math.Abs(v) < f.minNonZeroValue/2:
+			v = 0
+		case v > 0:
+			v = f.minNonZeroValue
+		default:
+			v = -f.minNonZeroValue
+		}
+	}
+	return formatFloatWithPrecision(v, precision)
+}
+
+func isZero(dp pmetric.NumberDataPoint) bool {
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		return dp.IntValue() == 0
+	case pmetric.NumberDataPointValueTypeDouble:
+		return dp.DoubleValue() == 0
+	}
+	return false
+}
+
+// environment returns the path segment of the deployment environment of the
+// resource, the default environment if it has none.
+func (f *formatter) environment(resource pcommon.Resource) string {
+	if env, ok := resource.Attributes().Get(conventions.AttributeDeploymentEnvironment); ok && env.AsString() != "" {
+		return sanitizePathNode(f.attributeValue(conventions.AttributeDeploymentEnvironment, env), f.separator)
+	}
+	return f.defaultEnvironment
+}
+
+// metricTags returns the tags added to every line generated for the metric.
+func (f *formatter) metricTags(metric pmetric.Metric) []tag {
+	var tags []tag
+	if f.aggregationMethodTag {
+		if method := f.aggregationMethod(metric); method != "" {
+			tags = append(tags, tag{key: aggregationMethodTagKey, value: method})
+		}
+	}
+	if f.pipelineTag != "" {
+		tags = append(tags, tag{key: pipelineTagKey, value: f.pipelineTag})
+	}
+	tags = append(tags, f.geoTags...)
+	return append(tags, f.tagsByMetric[metric.Name()]...)
+}
+
+// kubernetesTagKeys maps the Kubernetes resource attributes added as tags
+// when Config.KubernetesTags is enabled to their short tag keys.
+var kubernetesTagKeys = []struct {
+	attribute string
+	tagKey    string
+}{
+	{attribute: conventions.AttributeK8SNamespaceName, tagKey: "ns"},
+	{attribute: conventions.AttributeK8SPodName, tagKey: "pod"},
+	{attribute: conventions.AttributeK8SDeploymentName, tagKey: "deploy"},
+}
+
+// resourceTags returns the tags added to every line generated for the metrics
+// of the resource. When kubernetesTags is enabled the common Kubernetes
+// attributes are added with short tag keys and the resourceAttributeTags
+// with their own keys. When resourceAsJSONTag is enabled all the resource
+// attributes are encoded as JSON into a single URL-escaped "resource" tag.
+func (f *formatter) resourceTags(resource pcommon.Resource) []tag {
+	var tags []tag
+	for _, key := range f.resourceAttributeTags {
+		if v, ok := resource.Attributes().Get(key); ok {
+			tags = append(tags, tag{key: key, value: f.attributeValue(key, v)})
+		}
+	}
+	if f.kubernetesTags {
+		for _, k := range kubernetesTagKeys {
+			if v, ok := resource.Attributes().Get(k.attribute); ok {
+				tags = append(tags, tag{key: k.tagKey, value: f.attributeValue(k.attribute, v)})
+			}
+		}
+	}
+	if f.resourceAsJSONTag && resource.Attributes().Len() > 0 {
+		// Map keys are sorted by encoding/json, so the tag is stable.
+		if encoded, err := json.Marshal(f.rawAttributes(resource.Attributes())); err == nil {
+			tags = append(tags, tag{key: resourceTagKey, value: url.QueryEscape(string(encoded))})
+		}
+	}
+	return tags
+}
+
+// attributeTags returns the attributes as tags, with their keys sanitized as
+// the ones of the data point attributes.
+func (f *formatter) attributeTags(attributes pcommon.Map) []tag {
+	tags := make([]tag, 0, attributes.Len())
+	attributes.Range(func(k string, v pcommon.Value) bool {
+		key := sanitizeTagKey(k)
+		if f.tagKeyReplacer != nil {
+			key = f.tagKeyReplacer.Replace(key)
+		}
+		tags = append(tags, tag{key: key, value: f.attributeValue(k, v)})
+		return true
+	})
+	return tags
+}
+
+// attributeValue returns the value of the attribute with the given key as it
+// is written, hashed when the key is one of hashTagValues.
+func (f *formatter) attributeValue(key string, v pcommon.Value) string {
+	if _, ok := f.hashTagValues[key]; ok {
+		return f.hashTagValue(v.AsString())
+	}
+	return v.AsString()
+}
+
+// rawAttributes returns the attributes as raw values, with the ones of
+// hashTagValues hashed.
+func (f *formatter) rawAttributes(attributes pcommon.Map) map[string]any {
+	raw := attributes.AsRaw()
+	for key := range f.hashTagValues {
+		if v, ok := attributes.Get(key); ok {
+			raw[key] = f.hashTagValue(v.AsString())
+		}
+	}
+	return raw
+}
+
+// aggregationMethod returns the Graphite aggregation method that best rolls
+// up the series generated for the metric: gauges and the sums converted to
+// rates are averaged, delta values and the sums converted to deltas are
+// summed and cumulative values keep the highest (monotonic) or latest
+// (non-monotonic) value.
+func (f *formatter) aggregationMethod(metric pmetric.Metric) string {
+	switch metric.Type() {
+	case pmetric.MetricTypeGauge:
+		return aggregationMethodAverage
+	case pmetric.MetricTypeSum:
+		if f.convertsSum(metric) {
+			// The converted values are deltas or rates.
+			if f.sumConversion == sumConversionRate {
+				return aggregationMethodAverage
+			}
+			return aggregationMethodSum
+		}
+		if f.temporality(metric.Sum().AggregationTemporality()) == pmetric.AggregationTemporalityDelta {
+			return aggregationMethodSum
+		}
+		if metric.Sum().IsMonotonic() {
+			return aggregationMethodMax
+		}
+		return aggregationMethodLast
+	case pmetric.MetricTypeHistogram:
+		if f.temporality(metric.Histogram().AggregationTemporality()) == pmetric.AggregationTemporalityDelta {
+			return aggregationMethodSum
+		}
+		return aggregationMethodMax
+	case pmetric.MetricTypeSummary:
+		// Summaries are always cumulative.
+		return aggregationMethodMax
+	}
+	return ""
+}
 
-	sumPath := buildPath(metricName, attributes)
-	valueStr = formatFloatForValue(sum)
-	sb.WriteString(buildLine(sumPath, valueStr, timestampStr))
+// temporality returns t, or the configured default when t is unspecified.
+func (f *formatter) temporality(t pmetric.AggregationTemporality) pmetric.AggregationTemporality {
+	if t == pmetric.AggregationTemporalityUnspecified {
+		return f.defaultTemporality
+	}
+	return t
 }
 
-// buildPath is used to build the <metric_path> per description above.
-func buildPath(name string, attributes pcommon.Map) string {
-	if attributes.Len() == 0 {
+// buildPath is used to build the <metric_path> per description above. The
+// metricTags are added after the tags built from the attributes.
+func (f *formatter) buildPath(name string, attributes pcommon.Map, metricTags []tag) string {
+	if attributes.Len() == 0 && len(metricTags) == 0 {
 		return name
 	}
 
 	var sb strings.Builder
 	sb.WriteString(name)
 
-	attributes.Range(func(k string, v pcommon.Value) bool {
-		value := v.AsString()
+	tags := f.buildTags(attributes, metricTags)
+	if f.dottedTags && !f.deterministicOrder {
+		// The path identifies the series, so the order must not depend on
+		// the order of the attributes.
+		sort.SliceStable(tags, func(i, j int) bool {
+			return tags[i].key < tags[j].key
+		})
+	}
+	for _, t := range tags {
+		sb.WriteString(f.formatTag(t.key, t.value))
+	}
+
+	return sb.String()
+}
+
+// formatTag returns the tag as appended to a path, ie.: "<sep><key><kv><value>"
+// or, with dottedTags, "<separator><key><separator><value>" with both sanitized to be single
+// path nodes.
+func (f *formatter) formatTag(key, value string) string {
+	if f.dottedTags {
+		return f.separator + sanitizePathNode(key, f.separator) + f.separator + sanitizePathNode(value, f.separator)
+	}
+	return f.tagSeparator + key + f.tagKVSeparator + value
+}
+
+// tag is a single Carbon tag with an already sanitized key.
+type tag struct {
+	key   string
+	value string
+	// scopeAttribute is set for the tags of the scope attributes, which are
+	// dropped when a data point attribute has the same key.
+	scopeAttribute bool
+}
+
+// buildTags converts the attributes into Carbon tags followed by the given
+// metricTags. Keys that are identical after sanitization are resolved per the
+// configured tagKeyCollisionPolicy, by default only the first one is kept,
+// except the scope attributes whose keys are taken by the attributes, which are
+// always dropped. With deterministicOrder the tags are sorted by key.
+func (f *formatter) buildTags(attributes pcommon.Map, metricTags []tag) []tag {
+	tags := make([]tag, 0, attributes.Len()+len(metricTags))
+	index := make(map[string]int, attributes.Len()+len(metricTags))
+	add := func(key, value string) {
+		key = f.sanitizeName(key)
+		value = f.sanitizeName(f.applyUnicodePolicy(value))
 		if value == "" {
 			value = tagValueEmptyPlaceholder
 		}
-		sb.WriteString(tagPrefix + sanitizeTagKey(k) + tagKeyValueSeparator + value)
+
+		if i, ok := index[key]; ok {
+			switch f.tagKeyCollisionPolicy {
+			case tagKeyCollisionSuffix:
+				key = uniqueTagKey(key, index)
+			case tagKeyCollisionConcatValues:
+				tags[i].value += tagValueConcatSeparator + value
+				return
+			default:
+				return
+			}
+		}
+
+		index[key] = len(tags)
+		tags = append(tags, tag{key: key, value: value})
+	}
+
+	attributes.Range(func(k string, v pcommon.Value) bool {
+		key := sanitizeTagKey(k)
+		if f.tagKeyReplacer != nil {
+			key = f.tagKeyReplacer.Replace(key)
+		}
+		add(key, f.attributeValue(k, v))
 		return true
 	})
+	attributeTagCount := len(tags)
+	for _, t := range metricTags {
+		if t.scopeAttribute {
+			if i, ok := index[f.sanitizeName(t.key)]; ok && i < attributeTagCount {
+				continue
+			}
+		}
+		add(t.key, t.value)
+	}
 
-	return sb.String()
+	if f.deterministicOrder {
+		sort.SliceStable(tags, func(i, j int) bool {
+			return tags[i].key < tags[j].key
+		})
+	}
+
+	return tags
+}
+
+// hashTagValue returns the hex-encoded HMAC-SHA256 of value keyed with
+// hashSalt.
+func (f *formatter) hashTagValue(value string) string {
+	mac := hmac.New(sha256.New, f.hashSalt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// uniqueTagKey returns the first "<key>_<n>", with n starting at 1, that is
+// not yet used by any tag.
+func uniqueTagKey(key string, used map[string]int) string {
+	for n := 1; ; n++ {
+		candidate := key + string(sanitizedRune) + strconv.Itoa(n)
+		if _, ok := used[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+// joinPath returns path, one of the built-in paths or path fragments, with its
+// segments joined by the configured separator.
+func (f *formatter) joinPath(path string) string {
+	if f.separator == pathSeparator {
+		return path
+	}
+	return strings.ReplaceAll(path, pathSeparator, f.separator)
+}
+
+// generatedPath returns the path of the lines generated by the exporter
+// itself, path being one of the built-in paths, under pathPrefix like the
+// paths of the metrics.
+func (f *formatter) generatedPath(path string) string {
+	return f.pathPrefix + f.joinPath(path)
+}
+
+// shutdownMarker returns the line marking that the exporter stopped cleanly.
+func (f *formatter) shutdownMarker() string {
+	return f.lineAtNow(f.generatedPath(shutdownMarkerPath), "1")
+}
+
+// uptimeLine returns the line reporting, in whole seconds, for how long the
+// exporter has been running.
+func (f *formatter) uptimeLine(uptime time.Duration) string {
+	return f.lineAtNow(f.generatedPath(uptimePath), formatInt64(int64(uptime/time.Second)))
+}
+
+// activeSeriesLine returns the line reporting the number of distinct series
+// sent since the previous one.
+func (f *formatter) activeSeriesLine() string {
+	return f.lineAtNow(f.generatedPath(activeSeriesPath), strconv.Itoa(f.activeSeries.reset()))
+}
+
+// backpressureDropsLine returns the line reporting the number of data points
+// dropped due to backpressure since the start.
+func (f *formatter) backpressureDropsLine(drops int64) string {
+	return f.lineAtNow(f.generatedPath(backpressureDropsPath), formatInt64(drops))
+}
+
+// lineAtNow returns a line, built outside of the exports, timestamped now.
+func (f *formatter) lineAtNow(path, value string) string {
+	timestamp := formatTimestamp(pcommon.NewTimestampFromTime(f.clock.Now()))
+	if f.fieldOrder != nil {
+		return f.fieldOrder.buildLine(path, value, timestamp)
+	}
+	return buildLine(path, value, timestamp)
 }
 
 // buildLine builds a single Carbon metric textual line, ie.: it already adds
@@ -247,6 +1713,88 @@ func buildLine(path, value, timestamp string) string {
 	return path + " " + value + " " + timestamp + "\n"
 }
 
+// Placeholders supported by Config.FieldOrder.
+const (
+	fieldOrderName      = "name"
+	fieldOrderValue     = "value"
+	fieldOrderTimestamp = "timestamp"
+)
+
+// fieldOrder is the parsed form of Config.FieldOrder, it alternates the text
+// found in the template with the placeholders, so len(literals) is always
+// len(fields)+1.
+type fieldOrder struct {
+	literals []string
+	fields   []string
+}
+
+// parseFieldOrder parses templates like "{timestamp} {name} {value}" which
+// must reference each of the "name", "value" and "timestamp" fields exactly
+// once.
+func parseFieldOrder(template string) (*fieldOrder, error) {
+	if strings.ContainsAny(template, "\r\n") {
+		return nil, errors.New("field order cannot contain line breaks")
+	}
+
+	fo := &fieldOrder{}
+	seen := make(map[string]bool, 3)
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("field order %q has an unterminated placeholder", template)
+		}
+
+		field := rest[start+1 : start+end]
+		switch field {
+		case fieldOrderName, fieldOrderValue, fieldOrderTimestamp:
+		default:
+			return nil, fmt.Errorf("field order %q has an unknown placeholder {%s}", template, field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("field order %q references {%s} more than once", template, field)
+		}
+		seen[field] = true
+
+		fo.literals = append(fo.literals, rest[:start])
+		fo.fields = append(fo.fields, field)
+		rest = rest[start+end+1:]
+	}
+	fo.literals = append(fo.literals, rest)
+
+	for _, field := range []string{fieldOrderName, fieldOrderValue, fieldOrderTimestamp} {
+		if !seen[field] {
+			return nil, fmt.Errorf("field order %q must reference {%s}", template, field)
+		}
+	}
+
+	return fo, nil
+}
+
+// buildLine is the equivalent of the package level buildLine for the parsed
+// field order.
+func (fo *fieldOrder) buildLine(path, value, timestamp string) string {
+	var sb strings.Builder
+	for i, field := range fo.fields {
+		sb.WriteString(fo.literals[i])
+		switch field {
+		case fieldOrderName:
+			sb.WriteString(path)
+		case fieldOrderValue:
+			sb.WriteString(value)
+		case fieldOrderTimestamp:
+			sb.WriteString(timestamp)
+		}
+	}
+	sb.WriteString(fo.literals[len(fo.fields)])
+	sb.WriteString("\n")
+	return sb.String()
+}
+
 // sanitizeTagKey removes any invalid character from the tag key, the invalid
 // characters are ";!^=".
 func sanitizeTagKey(key string) string {
@@ -277,6 +1825,19 @@ func sanitizeTagValue(value string) string {
 	return strings.Map(mapRune, value)
 }
 
+// sanitizePathNode replaces the separator and the whitespace characters, which
+// would split the node or the line, with sanitizedRune.
+func sanitizePathNode(node, separator string) string {
+	mapRune := func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return sanitizedRune
+		}
+		return r
+	}
+
+	return strings.ReplaceAll(strings.Map(mapRune, node), separator, string(sanitizedRune))
+}
+
 // Formats a float64 per Prometheus label value. This is an attempt to keep other
 // the label values with different formats of metrics.
 func formatFloatForLabel(f float64) string {
@@ -288,6 +1849,14 @@ func formatFloatForValue(f float64) string {
 	return strconv.FormatFloat(f, 'f', -1, 64)
 }
 
+// Formats a float64 per Carbon plaintext format with the given number of
+// decimals, a negative precision uses the fewest digits needed to represent
+// the value exactly, as formatFloatForValue does. The fixed-point notation is
+// always used since Graphite rejects the scientific one, e.g. 1e-9.
+func formatFloatWithPrecision(f float64, precision int) string {
+	return strconv.FormatFloat(f, 'f', precision, 64)
+}
+
 func formatUint64(i uint64) string {
 	return strconv.FormatUint(i, 10)
 }
@@ -299,3 +1868,30 @@ func formatInt64(i int64) string {
 func formatTimestamp(timestamp pcommon.Timestamp) string {
 	return formatUint64(uint64(timestamp) / 1e9)
 }
+
+// pointTimestamp formats the timestamp of a data point, rounded down to a
+// multiple of the interval held by its intervalAttribute, if any.
+func (f *formatter) pointTimestamp(timestamp pcommon.Timestamp, attributes pcommon.Map) string {
+	if f.intervalAttribute == "" {
+		return formatTimestamp(timestamp)
+	}
+	v, ok := attributes.Get(f.intervalAttribute)
+	if !ok {
+		return formatTimestamp(timestamp)
+	}
+	var interval float64
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		interval = float64(v.Int())
+	case pcommon.ValueTypeDouble:
+		interval = v.Double()
+	case pcommon.ValueTypeStr:
+		interval, _ = strconv.ParseFloat(v.Str(), 64)
+	}
+	seconds := uint64(timestamp) / 1e9
+	// Intervals below a second would leave the timestamp as it is.
+	if !(interval >= 1) || interval > float64(seconds) {
+		return formatUint64(seconds)
+	}
+	return formatUint64(seconds - seconds%uint64(interval))
+}
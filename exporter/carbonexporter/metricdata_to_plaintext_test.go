@@ -4,14 +4,26 @@
 package carbonexporter
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	conventions "go.opentelemetry.io/collector/semconv/v1.9.0"
 )
 
 func TestSanitizeTagKey(t *testing.T) {
@@ -27,7 +39,7 @@ func TestSanitizeTagKey(t *testing.T) {
 		},
 		{
 			name: "remove_tag_set",
-			key:  "a" + tagKeyValueSeparator + "c",
+			key:  "a" + defaultTagKVSeparator + "c",
 			want: "a" + string(sanitizedRune) + "c",
 		},
 	}
@@ -106,7 +118,40 @@ func TestBuildPath(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildPath(tt.name, tt.attributes)
+			got := newTestFormatter(t, createDefaultConfig().(*Config)).buildPath(tt.name, tt.attributes, nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuildPathTagKeyCollision(t *testing.T) {
+	attributes := pcommon.NewMap()
+	attributes.PutStr("a=b", "v0")
+	attributes.PutStr("a_b", "v1")
+	attributes.PutStr("c", "v2")
+
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{
+			policy: tagKeyCollisionKeepFirst,
+			want:   "metric;a_b=v0;c=v2",
+		},
+		{
+			policy: tagKeyCollisionSuffix,
+			want:   "metric;a_b=v0;a_b_1=v1;c=v2",
+		},
+		{
+			policy: tagKeyCollisionConcatValues,
+			want:   "metric;a_b=v0,v1;c=v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.TagKeyCollisionPolicy = tt.policy
+			got := newTestFormatter(t, cfg).buildPath("metric", attributes, nil)
 			assert.Equal(t, tt.want, got)
 		})
 	}
@@ -276,7 +321,7 @@ func TestToPlaintext(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotLines := metricDataToPlaintext(tt.metricsDataFn())
+			gotLines := newTestFormatter(t, createDefaultConfig().(*Config)).metricDataToPlaintext(context.Background(), tt.metricsDataFn())
 			got := strings.Split(gotLines, "\n")
 			got = got[:len(got)-1]
 			assert.Equal(t, tt.wantLinesCount, len(got))
@@ -285,6 +330,706 @@ func TestToPlaintext(t *testing.T) {
 	}
 }
 
+func TestToPlaintextOptions(t *testing.T) {
+	// scopedMetrics builds a gauge in a versioned scope and a sum in an
+	// unversioned one.
+	scopedMetrics := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		sms := md.ResourceMetrics().AppendEmpty().ScopeMetrics()
+		versioned := sms.AppendEmpty()
+		versioned.Scope().SetName("receiver")
+		versioned.Scope().SetVersion("1.2.3")
+		gauge := versioned.Metrics().AppendEmpty()
+		gauge.SetName("gauge")
+		dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("k", "v")
+		dp.SetIntValue(1)
+		unversioned := sms.AppendEmpty()
+		unversioned.Scope().SetName("unversioned")
+		sum := unversioned.Metrics().AppendEmpty()
+		sum.SetName("sum")
+		sum.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(2)
+		return md
+	}
+	// namedScopes builds a gauge in each of the scopes "scope.a", "scope b"
+	// and a scope without a name.
+	namedScopes := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		sms := md.ResourceMetrics().AppendEmpty().ScopeMetrics()
+		for _, name := range []string{"scope.a", "scope b", ""} {
+			sm := sms.AppendEmpty()
+			sm.Scope().SetName(name)
+			sm.Scope().SetVersion("1.0")
+			gauge := sm.Metrics().AppendEmpty()
+			gauge.SetName("requests")
+			gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+		}
+		return md
+	}
+	// scopeAttributes builds a gauge in a scope with attributes, one of its
+	// points having an attribute named like one of the scope's.
+	scopeAttributes := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		sm := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+		sm.Scope().Attributes().PutStr("team", "payments")
+		sm.Scope().Attributes().PutStr("zone", "eu")
+		gauge := sm.Metrics().AppendEmpty()
+		gauge.SetName("requests")
+		dps := gauge.SetEmptyGauge().DataPoints()
+		dps.AppendEmpty().SetIntValue(1)
+		dp := dps.AppendEmpty()
+		dp.Attributes().PutStr("zone", "us")
+		dp.SetIntValue(2)
+		return md
+	}
+	// resourceAttributes builds a gauge of a resource with attributes.
+	resourceAttributes := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		rm := md.ResourceMetrics().AppendEmpty()
+		rm.Resource().Attributes().PutStr("service.name", "checkout")
+		rm.Resource().Attributes().PutStr("host.id", "4f9c6a3e-0b1d-4c6e-9a57-2f3d8e1b7c90")
+		m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("gauge")
+		dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("k0", "v0")
+		dp.SetIntValue(1)
+		return md
+	}
+	// gaugeAndSummary builds a gauge with an attribute and a summary without.
+	gaugeAndSummary := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+		gauge := ms.AppendEmpty()
+		gauge.SetName("gauge")
+		dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("k0", "v0")
+		dp.SetIntValue(1)
+		summary := ms.AppendEmpty()
+		summary.SetName("summary")
+		summary.SetEmptySummary().DataPoints().AppendEmpty().SetCount(2)
+		return md
+	}
+
+	tests := []struct {
+		name          string
+		metricsDataFn func() pmetric.Metrics
+		configure     func(cfg *Config)
+		wantLines     []string
+	}{
+		{
+			name: "field_order",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+				m.SetName("gauge")
+				dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+				dp.SetTimestamp(pcommon.Timestamp(1574092046 * time.Second))
+				dp.Attributes().PutStr("k0", "v0")
+				dp.SetIntValue(42)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.FieldOrder = "{timestamp} {name} {value}" },
+			wantLines: []string{"1574092046 gauge;k0=v0 42"},
+		},
+		{
+			name: "aggregation_method_tag",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				gauge := ms.AppendEmpty()
+				gauge.SetName("gauge")
+				gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+				deltaSum := ms.AppendEmpty()
+				deltaSum.SetName("delta_sum")
+				deltaSum.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+				deltaSum.Sum().DataPoints().AppendEmpty().SetIntValue(2)
+				counter := ms.AppendEmpty()
+				counter.SetName("counter")
+				counter.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				counter.Sum().SetIsMonotonic(true)
+				counter.Sum().DataPoints().AppendEmpty().SetIntValue(3)
+				upDownCounter := ms.AppendEmpty()
+				upDownCounter.SetName("up_down_counter")
+				upDownCounter.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+				upDownCounter.Sum().DataPoints().AppendEmpty().SetIntValue(4)
+				histogram := ms.AppendEmpty()
+				histogram.SetName("histogram")
+				histogram.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+				histogram.Histogram().DataPoints().AppendEmpty().SetCount(5)
+				summary := ms.AppendEmpty()
+				summary.SetName("summary")
+				summary.SetEmptySummary().DataPoints().AppendEmpty().SetCount(6)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.AggregationMethodTag = true },
+			wantLines: []string{
+				"gauge;aggregationMethod=average 1 0",
+				"delta_sum;aggregationMethod=sum 2 0",
+				"counter;aggregationMethod=max 3 0",
+				"up_down_counter;aggregationMethod=last 4 0",
+				"histogram.count;aggregationMethod=sum 5 0",
+				"histogram;aggregationMethod=sum 0 0",
+				"summary.count;aggregationMethod=max 6 0",
+				"summary;aggregationMethod=max 0 0",
+			},
+		},
+		{
+			name: "tag_separators",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				gauge := ms.AppendEmpty()
+				gauge.SetName("gauge")
+				dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+				dp.Attributes().PutStr("k0", "v0")
+				dp.Attributes().PutStr("k:1", "v1")
+				dp.Attributes().PutStr("url", "http://a,b")
+				dp.SetIntValue(1)
+				histogram := ms.AppendEmpty()
+				histogram.SetName("histogram")
+				hdp := histogram.SetEmptyHistogram().DataPoints().AppendEmpty()
+				hdp.Attributes().PutStr("k0", "v0")
+				hdp.SetCount(2)
+				hdp.ExplicitBounds().FromRaw([]float64{1})
+				hdp.BucketCounts().FromRaw([]uint64{1, 1})
+				return md
+			},
+			configure: func(cfg *Config) {
+				cfg.TagSeparator = ","
+				cfg.TagKVSeparator = ":"
+			},
+			wantLines: []string{
+				"gauge,k0:v0,k_1:v1,url:http_//a_b 1 0",
+				"histogram.count,k0:v0 2 0",
+				"histogram,k0:v0 0 0",
+				"histogram.bucket,k0:v0,upper_bound:1 1 0",
+				"histogram.bucket,k0:v0,upper_bound:inf 1 0",
+			},
+		},
+		{
+			name: "precision_by_unit",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				latency := ms.AppendEmpty()
+				latency.SetName("latency")
+				latency.SetUnit("ms")
+				latency.SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(1.23456)
+				requests := ms.AppendEmpty()
+				requests.SetName("requests")
+				requests.SetUnit("1")
+				requests.SetEmptySum().DataPoints().AppendEmpty().SetDoubleValue(2.6)
+				size := ms.AppendEmpty()
+				size.SetName("size")
+				size.SetUnit("By")
+				size.SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(0.125)
+				// Out of the int64 range, formatting without decimals must
+				// not overflow.
+				total := ms.AppendEmpty()
+				total.SetName("total")
+				total.SetUnit("1")
+				total.SetEmptySum().DataPoints().AppendEmpty().SetDoubleValue(-1e20)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.PrecisionByUnit = map[string]int{"ms": 3, "1": 0} },
+			wantLines: []string{
+				"latency 1.235 0",
+				"requests 3 0",
+				"size 0.125 0",
+				"total -100000000000000000000 0",
+			},
+		},
+		{
+			// Each point is formatted per its own value type, the precision
+			// only applies to the double values.
+			name: "mixed_value_types",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+				m.SetName("mixed")
+				dps := m.SetEmptySum().DataPoints()
+				dps.AppendEmpty().SetIntValue(1)
+				dps.AppendEmpty().SetDoubleValue(2.5)
+				dps.AppendEmpty().SetIntValue(-3)
+				dps.AppendEmpty().SetDoubleValue(4)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.PrecisionByUnit = map[string]int{"": 1} },
+			wantLines: []string{
+				"mixed 1 0",
+				"mixed 2.5 0",
+				"mixed -3 0",
+				"mixed 4.0 0",
+			},
+		},
+		{
+			name:          "pipeline_tag",
+			metricsDataFn: gaugeAndSummary,
+			configure:     func(cfg *Config) { cfg.PipelineTag = "primary" },
+			wantLines: []string{
+				"gauge;k0=v0;pipeline=primary 1 0",
+				"summary.count;pipeline=primary 2 0",
+				"summary;pipeline=primary 0 0",
+			},
+		},
+		{
+			// The geo tags are sorted by key.
+			name:          "geo_tags",
+			metricsDataFn: gaugeAndSummary,
+			configure:     func(cfg *Config) { cfg.GeoTags = map[string]string{"zone": "a", "region": "us-east-1"} },
+			wantLines: []string{
+				"gauge;k0=v0;region=us-east-1;zone=a 1 0",
+				"summary.count;region=us-east-1;zone=a 2 0",
+				"summary;region=us-east-1;zone=a 0 0",
+			},
+		},
+		{
+			// The metric tags are sorted by key, after the geo tags.
+			name: "metric_tags",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				for i, name := range []string{"requests", "errors"} {
+					m := ms.AppendEmpty()
+					m.SetName(name)
+					dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+					dp.Attributes().PutStr("k0", "v0")
+					dp.SetIntValue(int64(i))
+				}
+				return md
+			},
+			configure: func(cfg *Config) {
+				cfg.GeoTags = map[string]string{"region": "us-east-1"}
+				cfg.MetricTags = map[string]map[string]string{
+					"requests": {"team": "web", "owner": "sre"},
+					"missing":  {"team": "db"},
+				}
+			},
+			wantLines: []string{
+				"requests;k0=v0;region=us-east-1;owner=sre;team=web 0 0",
+				"errors;k0=v0;region=us-east-1 1 0",
+			},
+		},
+		{
+			name: "suppress_zeros",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				errs := ms.AppendEmpty()
+				errs.SetName("errors.total")
+				errs.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(0)
+				errs.Sum().DataPoints().AppendEmpty().SetIntValue(2)
+				ratio := ms.AppendEmpty()
+				ratio.SetName("errors.ratio")
+				ratio.SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(0)
+				requests := ms.AppendEmpty()
+				requests.SetName("requests")
+				requests.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(0)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.SuppressZeros = []string{`errors\..*`} },
+			wantLines: []string{
+				"errors.total 2 0",
+				"requests 0 0",
+			},
+		},
+		{
+			name: "monotonic_suffix",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				counter := ms.AppendEmpty()
+				counter.SetName("requests")
+				counter.SetEmptySum().SetIsMonotonic(true)
+				counter.Sum().DataPoints().AppendEmpty().SetIntValue(1)
+				upDown := ms.AppendEmpty()
+				upDown.SetName("connections")
+				upDown.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(2)
+				gauge := ms.AppendEmpty()
+				gauge.SetName("temperature")
+				gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(3)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.MonotonicSuffix = ".total" },
+			wantLines: []string{
+				"requests.total 1 0",
+				"connections 2 0",
+				"temperature 3 0",
+			},
+		},
+		{
+			// The lines are grouped per class after the unclassified ones.
+			name: "retention_classes",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				for i, name := range []string{"debug.queue", "requests", "other", "debug.cache", "errors"} {
+					m := ms.AppendEmpty()
+					m.SetName(name)
+					m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(int64(i))
+				}
+				return md
+			},
+			configure: func(cfg *Config) {
+				cfg.RetentionClasses = []RetentionClass{
+					{Pattern: `debug\..*`, Retention: "short"},
+					{Pattern: "requests|errors", Retention: "long"},
+				}
+			},
+			wantLines: []string{
+				"other 2 0",
+				"debug.queue;retention=short 0 0",
+				"debug.cache;retention=short 3 0",
+				"requests;retention=long 1 0",
+				"errors;retention=long 4 0",
+			},
+		},
+		{
+			name:          "resource_attributes_as_tags",
+			metricsDataFn: resourceAttributes,
+			configure: func(cfg *Config) {
+				cfg.ResourceAttributesAsTags = []string{"service.name", "deployment.environment"}
+			},
+			wantLines: []string{"gauge;k0=v0;service.name=checkout 1 0"},
+		},
+		{
+			// Without the list the resource attributes are left to
+			// resource_to_telemetry_conversion.
+			name:          "resource_attributes_as_tags_unset",
+			metricsDataFn: resourceAttributes,
+			configure:     func(*Config) {},
+			wantLines:     []string{"gauge;k0=v0 1 0"},
+		},
+		{
+			// The missing deployment is omitted, other attributes are not
+			// tags.
+			name: "kubernetes_tags",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				rm := md.ResourceMetrics().AppendEmpty()
+				rm.Resource().Attributes().PutStr("k8s.namespace.name", "prod")
+				rm.Resource().Attributes().PutStr("k8s.pod.name", "api-7d4b9")
+				rm.Resource().Attributes().PutStr("host.name", "node-1")
+				m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+				m.SetName("gauge")
+				dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+				dp.Attributes().PutStr("k0", "v0")
+				dp.SetIntValue(1)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.KubernetesTags = true },
+			wantLines: []string{"gauge;k0=v0;ns=prod;pod=api-7d4b9 1 0"},
+		},
+		{
+			name: "resourceless_prefix",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				for _, service := range []string{"", "checkout"} {
+					rm := md.ResourceMetrics().AppendEmpty()
+					if service != "" {
+						rm.Resource().Attributes().PutStr(conventions.AttributeServiceName, service)
+					}
+					m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+					m.SetName("requests")
+					m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+				}
+				return md
+			},
+			configure: func(cfg *Config) { cfg.ResourcelessPrefix = "unknown_service" },
+			wantLines: []string{
+				"unknown_service.requests 1 0",
+				"requests 1 0",
+			},
+		},
+		{
+			name:          "include_scope_version_tag",
+			metricsDataFn: scopedMetrics,
+			configure:     func(cfg *Config) { cfg.IncludeScopeVersionTag = true },
+			wantLines: []string{
+				"gauge;k=v;scope_version=1.2.3 1 0",
+				"sum 2 0",
+			},
+		},
+		{
+			name:          "include_scope_version_tag_disabled",
+			metricsDataFn: scopedMetrics,
+			configure:     func(cfg *Config) { cfg.IncludeScopeVersionTag = false },
+			wantLines: []string{
+				"gauge;k=v 1 0",
+				"sum 2 0",
+			},
+		},
+		{
+			name:          "include_scope",
+			metricsDataFn: namedScopes,
+			configure: func(cfg *Config) {
+				cfg.Prefix = "prod"
+				cfg.IncludeScope = true
+			},
+			wantLines: []string{
+				"prod.scope_a.requests 1 0",
+				"prod.scope_b.requests 1 0",
+				"prod.requests 1 0",
+			},
+		},
+		{
+			name:          "include_scope_with_version_tag",
+			metricsDataFn: namedScopes,
+			configure: func(cfg *Config) {
+				cfg.Prefix = "prod"
+				cfg.IncludeScope = true
+				cfg.IncludeScopeVersionTag = true
+			},
+			wantLines: []string{
+				"prod.scope_a.requests;scope_version=1.0 1 0",
+				"prod.scope_b.requests;scope_version=1.0 1 0",
+				"prod.requests;scope_version=1.0 1 0",
+			},
+		},
+		{
+			name:          "include_scope_attributes_disabled",
+			metricsDataFn: scopeAttributes,
+			configure:     func(*Config) {},
+			wantLines: []string{
+				"requests 1 0",
+				"requests;zone=us 2 0",
+			},
+		},
+		{
+			// The data point attributes win over the scope attributes
+			// whatever the collision policy.
+			name:          "include_scope_attributes_keep_first",
+			metricsDataFn: scopeAttributes,
+			configure: func(cfg *Config) {
+				cfg.IncludeScopeAttributes = true
+				cfg.TagKeyCollisionPolicy = tagKeyCollisionKeepFirst
+			},
+			wantLines: []string{
+				"requests;team=payments;zone=eu 1 0",
+				"requests;zone=us;team=payments 2 0",
+			},
+		},
+		{
+			name:          "include_scope_attributes_suffix",
+			metricsDataFn: scopeAttributes,
+			configure: func(cfg *Config) {
+				cfg.IncludeScopeAttributes = true
+				cfg.TagKeyCollisionPolicy = tagKeyCollisionSuffix
+			},
+			wantLines: []string{
+				"requests;team=payments;zone=eu 1 0",
+				"requests;zone=us;team=payments 2 0",
+			},
+		},
+		{
+			name:          "include_scope_attributes_concat_values",
+			metricsDataFn: scopeAttributes,
+			configure: func(cfg *Config) {
+				cfg.IncludeScopeAttributes = true
+				cfg.TagKeyCollisionPolicy = tagKeyCollisionConcatValues
+			},
+			wantLines: []string{
+				"requests;team=payments;zone=eu 1 0",
+				"requests;zone=us;team=payments 2 0",
+			},
+		},
+		{
+			name: "separator",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				gauge := ms.AppendEmpty()
+				gauge.SetName("test_0")
+				dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+				dp.Attributes().PutStr("k0", "v0")
+				dp.SetIntValue(1)
+				summary := ms.AppendEmpty()
+				summary.SetName("latency")
+				summary.SetEmptySummary().DataPoints().AppendEmpty().SetCount(3)
+				return md
+			},
+			configure: func(cfg *Config) {
+				cfg.Prefix = "prefix"
+				cfg.Separator = "_"
+			},
+			wantLines: []string{
+				"prefix_test_0;k0=v0 1 0",
+				"prefix_latency_count 3 0",
+				"prefix_latency 0 0",
+			},
+		},
+		{
+			// The separator found in the scope and the attributes is
+			// replaced, so they stay single path nodes, unlike the dots.
+			name: "separator_in_path_nodes",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				sm := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+				sm.Scope().SetName("io/http")
+				m := sm.Metrics().AppendEmpty()
+				m.SetName("requests")
+				dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+				dp.Attributes().PutStr("route", "/api/v1")
+				dp.Attributes().PutStr("host.name", "a.b")
+				dp.SetIntValue(1)
+				return md
+			},
+			configure: func(cfg *Config) {
+				cfg.MetricsFormat = metricsFormatDotted
+				cfg.Separator = "/"
+				cfg.IncludeScope = true
+			},
+			wantLines: []string{"io_http/requests/host.name/a.b/route/_api_v1 1 0"},
+		},
+		{
+			name: "interval_attribute",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+				gauge := ms.AppendEmpty()
+				gauge.SetName("cpu")
+				dps := gauge.SetEmptyGauge().DataPoints()
+				for _, interval := range []any{int64(100), "30", 0.5, nil} {
+					dp := dps.AppendEmpty()
+					dp.SetTimestamp(pcommon.Timestamp(1037 * time.Second))
+					dp.SetIntValue(1)
+					if interval != nil {
+						require.NoError(t, dp.Attributes().FromRaw(map[string]any{"interval": interval}))
+					}
+				}
+				histogram := ms.AppendEmpty()
+				histogram.SetName("latency")
+				hdp := histogram.SetEmptyHistogram().DataPoints().AppendEmpty()
+				hdp.SetTimestamp(pcommon.Timestamp(1037 * time.Second))
+				hdp.Attributes().PutInt("interval", 100)
+				hdp.SetCount(1)
+				return md
+			},
+			configure: func(cfg *Config) { cfg.IntervalAttribute = "interval" },
+			wantLines: []string{
+				"cpu;interval=100 1 1000",
+				"cpu;interval=30 1 1020",
+				"cpu;interval=0.5 1 1037",
+				"cpu 1 1037",
+				"latency.count;interval=100 1 1000",
+				"latency;interval=100 0 1000",
+			},
+		},
+		{
+			// The NaN quantile is skipped.
+			name: "summary_quantiles",
+			metricsDataFn: func() pmetric.Metrics {
+				md := pmetric.NewMetrics()
+				m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+				m.SetName("latency")
+				dp := m.SetEmptySummary().DataPoints().AppendEmpty()
+				dp.Attributes().PutStr("k0", "v0")
+				dp.SetCount(10)
+				dp.SetSum(12.5)
+				for _, q := range [][2]float64{{0.5, 1}, {0.9, 2.5}, {0.99, math.NaN()}} {
+					qv := dp.QuantileValues().AppendEmpty()
+					qv.SetQuantile(q[0])
+					qv.SetValue(q[1])
+				}
+				return md
+			},
+			configure: func(*Config) {},
+			wantLines: []string{
+				"latency.count;k0=v0 10 0",
+				"latency;k0=v0 12.5 0",
+				"latency.quantile;k0=v0;quantile=50 1 0",
+				"latency.quantile;k0=v0;quantile=90 2.5 0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			tt.configure(cfg)
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), tt.metricsDataFn())
+			assert.Equal(t, tt.wantLines, strings.Split(strings.TrimSuffix(got, "\n"), "\n"))
+		})
+	}
+}
+
+func TestToPlaintextMaxPointAge(t *testing.T) {
+	set, reader := newTestTelemetrySettings()
+	telemetry, err := newExporterTelemetry(set)
+	require.NoError(t, err)
+
+	now := time.Now()
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dps := m.SetEmptyGauge().DataPoints()
+	recent := dps.AppendEmpty()
+	recent.SetTimestamp(pcommon.NewTimestampFromTime(now.Add(-time.Minute)))
+	recent.SetIntValue(1)
+	old := dps.AppendEmpty()
+	old.SetTimestamp(pcommon.NewTimestampFromTime(now.Add(-2 * time.Hour)))
+	old.SetIntValue(2)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MaxPointAge = time.Hour
+	f, err := newFormatter(cfg, telemetry, realClock{})
+	require.NoError(t, err)
+	got := f.metricDataToPlaintext(context.Background(), md)
+
+	assert.Equal(t, "gauge 1 "+formatTimestamp(recent.Timestamp())+"\n", got)
+	assert.Equal(t, map[string]int64{dropReasonTooOld: 1}, collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey))
+}
+
+func TestParseFieldOrder(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{
+			template: "{name} {value} {timestamp}",
+		},
+		{
+			template: "{timestamp}|{value}|{name}",
+		},
+		{
+			template: "{name} {value}",
+			wantErr:  "must reference {timestamp}",
+		},
+		{
+			template: "{name} {value} {timestamp} {value}",
+			wantErr:  "references {value} more than once",
+		},
+		{
+			template: "{name} {value} {time}",
+			wantErr:  "unknown placeholder {time}",
+		},
+		{
+			template: "{name} {value} {timestamp",
+			wantErr:  "unterminated placeholder",
+		},
+		{
+			template: "{name}\n{value} {timestamp}",
+			wantErr:  "line breaks",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := parseFieldOrder(tt.template)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// newTestFormatter returns a formatter for cfg discarding its telemetry.
+func newTestFormatter(t testing.TB, cfg *Config) *formatter {
+	f, err := newFormatter(cfg, newNopTelemetry(t), realClock{})
+	require.NoError(t, err)
+	return f
+}
+
 func expectedDistributionLines(
 	metricName string,
 	tagsCombinations []string,
@@ -331,3 +1076,937 @@ func expectedSummaryLines(
 	}
 	return lines
 }
+
+func TestToPlaintextEscapeSeparatorInName(t *testing.T) {
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	m := ms.AppendEmpty()
+	m.SetName("app.v1.2.latency")
+	m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	m = ms.AppendEmpty()
+	m.SetName("app/v3/4/latency")
+	m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(2)
+
+	tests := []struct {
+		name      string
+		escape    bool
+		separator string
+		want      string
+	}{
+		{
+			name: "disabled",
+			want: "app.v1.2.latency 1 0\napp/v3/4/latency 2 0\n",
+		},
+		{
+			name:   "enabled",
+			escape: true,
+			want:   "app.v1_2.latency 1 0\napp/v3/4/latency 2 0\n",
+		},
+		{
+			name:      "custom_separator",
+			escape:    true,
+			separator: "/",
+			want:      "app.v1.2.latency 1 0\napp/v3_4/latency 2 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.EscapeSeparatorInName = tt.escape
+			cfg.Separator = tt.separator
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToPlaintextResourceAttributesAsJSONTag(t *testing.T) {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "test carbon")
+	rm.Resource().Attributes().PutBool("debug", true)
+	rm.Resource().Attributes().PutDouble("weight", 0.5)
+	m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("k0", "v0")
+	dp.SetIntValue(1)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.ResourceAttributesAsJSONTag = true
+	got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+
+	prefix := "gauge;k0=v0;resource="
+	suffix := " 1 0\n"
+	require.True(t, strings.HasPrefix(got, prefix), "unexpected line %q", got)
+	require.True(t, strings.HasSuffix(got, suffix), "unexpected line %q", got)
+	encoded := strings.TrimSuffix(strings.TrimPrefix(got, prefix), suffix)
+	assert.NotContains(t, encoded, ";")
+	assert.NotContains(t, encoded, " ")
+
+	decoded, err := url.QueryUnescape(encoded)
+	require.NoError(t, err)
+	var attrs map[string]any
+	require.NoError(t, json.Unmarshal([]byte(decoded), &attrs))
+	assert.Equal(t, rm.Resource().Attributes().AsRaw(), attrs)
+}
+
+func TestToPlaintextBatchSentinel(t *testing.T) {
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	cfg := createDefaultConfig().(*Config)
+	cfg.BatchSentinel = "carbon.batch"
+	f, err := newFormatter(cfg, telemetry, clock)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("gauge")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	summary := ms.AppendEmpty()
+	summary.SetName("summary")
+	summary.SetEmptySummary().DataPoints().AppendEmpty().SetCount(2)
+
+	// The summary point is written in two lines.
+	assert.Equal(t,
+		"gauge 1 0\n"+
+			"summary.count 2 0\n"+
+			"summary 0 0\n"+
+			"carbon.batch 2 1701424800\n",
+		f.metricDataToPlaintext(context.Background(), md))
+}
+
+func TestToPlaintextEmitDropSamples(t *testing.T) {
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	cfg := createDefaultConfig().(*Config)
+	cfg.EmitDropSamples = true
+	cfg.MaxPointAge = time.Hour
+	cfg.SuppressZeros = []string{"errors"}
+	f, err := newFormatter(cfg, telemetry, clock)
+	require.NoError(t, err)
+
+	ts := pcommon.NewTimestampFromTime(clock.Now())
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	errs := ms.AppendEmpty()
+	errs.SetName("errors")
+	errs.SetEmptySum()
+	for i := 0; i < 2; i++ {
+		dp := errs.Sum().DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("k0", "v0")
+		dp.SetTimestamp(ts)
+		dp.SetIntValue(0)
+	}
+	late := ms.AppendEmpty()
+	late.SetName("late")
+	late.SetEmptySummary().DataPoints().AppendEmpty().SetCount(3)
+
+	assert.Equal(t, []string{
+		"dropped.errors;k0=v0;drop_reason=zero_value 0 1701424800",
+		"dropped.late.count;drop_reason=too_old 3 1701424800",
+	}, strings.Split(strings.TrimSuffix(f.metricDataToPlaintext(context.Background(), md), "\n"), "\n"))
+
+	// Samples are rate limited per reason.
+	clock.Advance(dropSampleInterval / 2)
+	assert.Empty(t, f.metricDataToPlaintext(context.Background(), md))
+	clock.Advance(dropSampleInterval / 2)
+	assert.NotEmpty(t, f.metricDataToPlaintext(context.Background(), md))
+}
+
+func TestToPlaintextDeterministicOrder(t *testing.T) {
+	// generate builds the same data with resources, metrics, data points and
+	// attributes in the given or reversed order.
+	generate := func(reversed bool) pmetric.Metrics {
+		order := func(n int) []int {
+			idx := make([]int, n)
+			for i := range idx {
+				idx[i] = i
+				if reversed {
+					idx[i] = n - 1 - i
+				}
+			}
+			return idx
+		}
+		md := pmetric.NewMetrics()
+		for _, r := range order(2) {
+			rm := md.ResourceMetrics().AppendEmpty()
+			ms := rm.ScopeMetrics().AppendEmpty().Metrics()
+			for _, m := range order(2) {
+				metric := ms.AppendEmpty()
+				metric.SetName("metric_" + strconv.Itoa(r) + "_" + strconv.Itoa(m))
+				dps := metric.SetEmptyGauge().DataPoints()
+				for _, d := range order(2) {
+					dp := dps.AppendEmpty()
+					for _, k := range order(3) {
+						dp.Attributes().PutStr("k"+strconv.Itoa(k), "v"+strconv.Itoa(d))
+					}
+					dp.SetIntValue(int64(d))
+				}
+			}
+		}
+		return md
+	}
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.DeterministicOrder = true
+	f := newTestFormatter(t, cfg)
+	want := f.metricDataToPlaintext(context.Background(), generate(false))
+	assert.Equal(t, want, f.metricDataToPlaintext(context.Background(), generate(true)))
+	assert.Equal(t, "metric_0_0;k0=v0;k1=v0;k2=v0 0 0\n", want[:strings.IndexByte(want, '\n')+1])
+
+	// The lines are sorted before being capped, so the same lines are kept
+	// whatever the order of the data.
+	cfg.MaxLinesPerExport = 3
+	f = newTestFormatter(t, cfg)
+	for _, reversed := range []bool{false, true} {
+		assert.Equal(t,
+			"metric_0_0;k0=v0;k1=v0;k2=v0 0 0\n"+
+				"metric_0_0;k0=v1;k1=v1;k2=v1 1 0\n"+
+				"metric_0_1;k0=v0;k1=v0;k2=v0 0 0\n",
+			f.metricDataToPlaintext(context.Background(), generate(reversed)))
+	}
+	cfg.MaxLinesPerExport = 0
+
+	cfg.DeterministicOrder = false
+	f = newTestFormatter(t, cfg)
+	assert.NotEqual(t,
+		f.metricDataToPlaintext(context.Background(), generate(false)),
+		f.metricDataToPlaintext(context.Background(), generate(true)))
+}
+
+func TestToPlaintextSmallFloats(t *testing.T) {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dps := m.SetEmptyGauge().DataPoints()
+	for _, v := range []float64{1e-9, 7e-7, -7e-7, 0.5} {
+		dps.AppendEmpty().SetDoubleValue(v)
+	}
+
+	tests := []struct {
+		name            string
+		minNonZeroValue float64
+		want            []string
+	}{
+		{
+			name: "fixed_point",
+			want: []string{
+				"gauge 0.000000001 0",
+				"gauge 0.0000007 0",
+				"gauge -0.0000007 0",
+				"gauge 0.5 0",
+			},
+		},
+		{
+			name:            "min_non_zero_value",
+			minNonZeroValue: 1e-6,
+			want: []string{
+				"gauge 0 0",
+				"gauge 0.000001 0",
+				"gauge -0.000001 0",
+				"gauge 0.5 0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.MinNonZeroValue = tt.minNonZeroValue
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, strings.Split(strings.TrimSuffix(got, "\n"), "\n"))
+		})
+	}
+}
+
+func TestToPlaintextEmitScopeMeta(t *testing.T) {
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	cfg := createDefaultConfig().(*Config)
+	cfg.EmitScopeMeta = true
+	f, err := newFormatter(cfg, telemetry, clock)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	for i := 0; i < 2; i++ {
+		sms := md.ResourceMetrics().AppendEmpty().ScopeMetrics()
+		sm := sms.AppendEmpty()
+		sm.Scope().SetName("receiver")
+		sm.Scope().SetVersion("1.0")
+		m := sm.Metrics().AppendEmpty()
+		m.SetName("gauge")
+		m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(int64(i))
+		// Scopes without metrics are not identified.
+		sms.AppendEmpty().Scope().SetName("empty")
+	}
+	sm := md.ResourceMetrics().At(0).ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("unversioned")
+	m := sm.Metrics().AppendEmpty()
+	m.SetName("sum")
+	m.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(2)
+
+	assert.Equal(t, []string{
+		"otel.scope;name=receiver;version=1.0 1 1701424800",
+		"gauge 0 0",
+		"otel.scope;name=unversioned;version=<empty> 1 1701424800",
+		"sum 2 0",
+		"gauge 1 0",
+	}, strings.Split(strings.TrimSuffix(f.metricDataToPlaintext(context.Background(), md), "\n"), "\n"))
+}
+
+func TestToPlaintextConcurrentDuplicatePolicy(t *testing.T) {
+	const producers = 10
+	tests := []struct {
+		policy  string
+		highest float64
+	}{
+		{policy: duplicatePolicyMax, highest: producers},
+		{policy: duplicatePolicySum, highest: producers * (producers + 1) / 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.CrossExportDedupWindow = time.Minute
+			cfg.ConcurrentDuplicatePolicy = tt.policy
+			f := newTestFormatter(t, cfg)
+
+			// Each producer exports the same series and timestamp with its
+			// own value.
+			var wg sync.WaitGroup
+			outputs := make([]string, producers)
+			for i := 0; i < producers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					md := pmetric.NewMetrics()
+					m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+					m.SetName("requests")
+					m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(int64(i + 1))
+					outputs[i] = f.metricDataToPlaintext(context.Background(), md)
+				}(i)
+			}
+			wg.Wait()
+
+			// Whatever the order the producers ran in, the highest value
+			// written is the one of the policy.
+			highest := 0.0
+			for _, output := range outputs {
+				if output == "" {
+					continue
+				}
+				fields := strings.Fields(output)
+				require.Len(t, fields, 3)
+				value, err := strconv.ParseFloat(fields[1], 64)
+				require.NoError(t, err)
+				highest = math.Max(highest, value)
+			}
+			assert.Equal(t, tt.highest, highest)
+		})
+	}
+}
+
+func TestToPlaintextPathTemplate(t *testing.T) {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("deployment.environment", "prod")
+	rm.Resource().Attributes().PutStr("service.name", "checkout")
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("http")
+	m := sm.Metrics().AppendEmpty()
+	m.SetName("requests")
+	dps := m.SetEmptyGauge().DataPoints()
+	for i, code := range []string{"200", "500", "200"} {
+		dp := dps.AppendEmpty()
+		dp.Attributes().PutStr("code", code)
+		dp.SetIntValue(int64(i))
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{
+			name:     "default",
+			template: "",
+			want:     []string{"requests;code=200 0 0", "requests;code=500 1 0", "requests;code=200 2 0"},
+		},
+		{
+			name:     "resource_attributes",
+			template: `{{index .ResourceAttributes "deployment.environment"}}.{{index .ResourceAttributes "service.name"}}.{{.MetricName}}`,
+			want: []string{
+				"prod.checkout.requests;code=200 0 0",
+				"prod.checkout.requests;code=500 1 0",
+				"prod.checkout.requests;code=200 2 0",
+			},
+		},
+		{
+			name:     "data_point_attributes",
+			template: `{{.Scope.Name}}.{{.MetricName}}.{{.Attributes.code}}`,
+			want: []string{
+				"http.requests.200;code=200 0 0",
+				"http.requests.200;code=200 2 0",
+				"http.requests.500;code=500 1 0",
+			},
+		},
+		{
+			// The rendered name is sanitized like the metric names.
+			name:     "sanitized",
+			template: `{{.MetricName}}.{{.Attributes.code}};env=prod x`,
+			want: []string{
+				"requests.200_env_prod_x;code=200 0 0",
+				"requests.200_env_prod_x;code=200 2 0",
+				"requests.500_env_prod_x;code=500 1 0",
+			},
+		},
+		{
+			name:     "empty",
+			template: `{{if false}}unused{{end}}`,
+			want:     []string{"requests;code=200 0 0", "requests;code=500 1 0", "requests;code=200 2 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.PathTemplate = tt.template
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, strings.Split(strings.TrimSuffix(got, "\n"), "\n"))
+		})
+	}
+}
+
+func TestToPlaintextHashTagValues(t *testing.T) {
+	md := pmetric.NewMetrics()
+	gauge := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	gauge.SetName("logins")
+	dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("user.email", "jane@example.com")
+	dp.Attributes().PutStr("region", "eu")
+	dp.SetIntValue(1)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.HashTagValues = []string{"user.email"}
+	cfg.HashTagValuesSalt = "s3cr3t"
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("jane@example.com"))
+	want := "logins;user.email=" + hex.EncodeToString(mac.Sum(nil)) + ";region=eu 1 0\n"
+	assert.Equal(t, want, newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md))
+
+	// The hash depends on the salt.
+	cfg.HashTagValuesSalt = "other"
+	assert.NotEqual(t, want, newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md))
+}
+
+func TestToPlaintextHashTagValuesEverywhere(t *testing.T) {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("user.email", "jane@example.com")
+	rm.Resource().Attributes().PutStr("k8s.pod.name", "checkout-1")
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().Attributes().PutStr("tenant", "acme")
+	gauge := sm.Metrics().AppendEmpty()
+	gauge.SetName("logins")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+
+	hash := func(value string) string {
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name   string
+		update func(cfg *Config)
+		want   string
+	}{
+		{
+			name:   "resource_attributes_as_tags",
+			update: func(cfg *Config) { cfg.ResourceAttributesAsTags = []string{"user.email"} },
+			want:   "logins;user.email=" + hash("jane@example.com") + " 1 0\n",
+		},
+		{
+			name: "kubernetes_tags",
+			update: func(cfg *Config) {
+				cfg.KubernetesTags = true
+				cfg.HashTagValues = []string{"k8s.pod.name"}
+			},
+			want: "logins;pod=" + hash("checkout-1") + " 1 0\n",
+		},
+		{
+			name:   "resource_json_tag",
+			update: func(cfg *Config) { cfg.ResourceAttributesAsJSONTag = true },
+			want: "logins;resource=" + url.QueryEscape(`{"k8s.pod.name":"checkout-1","user.email":"`+hash("jane@example.com")+`"}`) +
+				" 1 0\n",
+		},
+		{
+			name: "scope_attributes",
+			update: func(cfg *Config) {
+				cfg.IncludeScopeAttributes = true
+				cfg.HashTagValues = []string{"tenant"}
+			},
+			want: "logins;tenant=" + hash("acme") + " 1 0\n",
+		},
+		{
+			name:   "path_template",
+			update: func(cfg *Config) { cfg.PathTemplate = `{{index .ResourceAttributes "user.email"}}.{{.MetricName}}` },
+			want:   hash("jane@example.com") + ".logins 1 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.HashTagValues = []string{"user.email"}
+			cfg.HashTagValuesSalt = "s3cr3t"
+			tt.update(cfg)
+			assert.Equal(t, tt.want, newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md))
+		})
+	}
+}
+
+func TestToPlaintextUnicodePolicy(t *testing.T) {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("café.latência")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("city", "são_paulo")
+	dp.SetIntValue(1)
+
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{
+			policy: unicodePolicyKeep,
+			want:   "café.latência;city=são_paulo 1 0\n",
+		},
+		{
+			policy: unicodePolicyASCIITransliterate,
+			want:   "cafe.latencia;city=sao_paulo 1 0\n",
+		},
+		{
+			policy: unicodePolicyStrip,
+			want:   "caf.latncia;city=so_paulo 1 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.UnicodePolicy = tt.policy
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToPlaintextEnvironmentInPath(t *testing.T) {
+	md := pmetric.NewMetrics()
+	for _, env := range []string{"prod", "stage.eu", ""} {
+		rm := md.ResourceMetrics().AppendEmpty()
+		if env != "" {
+			rm.Resource().Attributes().PutStr(conventions.AttributeDeploymentEnvironment, env)
+		}
+		m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	}
+
+	tests := []struct {
+		name               string
+		defaultEnvironment string
+		want               []string
+	}{
+		{
+			name:               "default",
+			defaultEnvironment: defaultEnvironment,
+			want: []string{
+				"otel.prod.requests 1 0",
+				"otel.stage_eu.requests 1 0",
+				"otel.unknown.requests 1 0",
+			},
+		},
+		{
+			name: "empty_default",
+			want: []string{
+				"otel.prod.requests 1 0",
+				"otel.stage_eu.requests 1 0",
+				"otel.requests 1 0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.Prefix = "otel"
+			cfg.EnvironmentInPath = EnvironmentInPathConfig{Enabled: true, Default: tt.defaultEnvironment}
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, strings.Split(strings.TrimSuffix(got, "\n"), "\n"))
+		})
+	}
+}
+
+func TestToPlaintextMergeHistogramFragments(t *testing.T) {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("latency")
+	dps := m.SetEmptyHistogram().DataPoints()
+	// The count and sum arrive apart from the buckets, themselves split in two
+	// fragments.
+	dp := dps.AppendEmpty()
+	dp.Attributes().PutStr("k0", "v0")
+	dp.SetCount(3)
+	dp.SetSum(12)
+	for _, counts := range [][]uint64{{1, 0, 0}, {0, 1, 1}} {
+		dp = dps.AppendEmpty()
+		dp.Attributes().PutStr("k0", "v0")
+		dp.ExplicitBounds().FromRaw([]float64{1, 5})
+		dp.BucketCounts().FromRaw(counts)
+	}
+	// A data point of another series is left as it is.
+	dp = dps.AppendEmpty()
+	dp.Attributes().PutStr("k0", "v1")
+	dp.SetCount(1)
+	dp.SetSum(2)
+	dp.ExplicitBounds().FromRaw([]float64{1, 5})
+	dp.BucketCounts().FromRaw([]uint64{0, 1, 0})
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MergeHistogramFragments = true
+	got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+
+	assert.Equal(t, []string{
+		"latency.count;k0=v0 3 0",
+		"latency;k0=v0 12 0",
+		"latency.bucket;k0=v0;upper_bound=1 1 0",
+		"latency.bucket;k0=v0;upper_bound=5 1 0",
+		"latency.bucket;k0=v0;upper_bound=inf 1 0",
+		"latency.count;k0=v1 1 0",
+		"latency;k0=v1 2 0",
+		"latency.bucket;k0=v1;upper_bound=1 0 0",
+		"latency.bucket;k0=v1;upper_bound=5 1 0",
+		"latency.bucket;k0=v1;upper_bound=inf 0 0",
+	}, strings.Split(strings.TrimSuffix(got, "\n"), "\n"))
+	// The data points of the metric are left untouched.
+	assert.Equal(t, 4, dps.Len())
+}
+
+func TestToPlaintextPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "", want: "test_0;k0=v0;k1=v1 0"},
+		{prefix: "prod", want: "prod.test_0;k0=v0;k1=v1 0"},
+		{prefix: "prod.", want: "prod.test_0;k0=v0;k1=v1 0"},
+		{prefix: "prod.collector", want: "prod.collector.test_0;k0=v0;k1=v1 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.Prefix = tt.prefix
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), generateSmallBatch())
+			assert.True(t, strings.HasPrefix(got, tt.want+" "), got)
+		})
+	}
+}
+
+func TestToPlaintextPrefixGeneratedPaths(t *testing.T) {
+	clock := newFakeClock(time.Unix(1701424800, 0))
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Prefix = "prod"
+	cfg.BatchSentinel = "carbon.batch"
+	cfg.EmitScopeMeta = true
+	cfg.EmitDropSamples = true
+	cfg.SuppressZeros = []string{"errors"}
+	f, err := newFormatter(cfg, telemetry, clock)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	sm := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("receiver")
+	sm.Scope().SetVersion("1.0")
+	gauge := sm.Metrics().AppendEmpty()
+	gauge.SetName("gauge")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	errs := sm.Metrics().AppendEmpty()
+	errs.SetName("errors")
+	errs.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(0)
+
+	// The lines generated by the exporter are under the prefix too.
+	assert.Equal(t, []string{
+		"prod.otel.scope;name=receiver;version=1.0 1 1701424800",
+		"prod.gauge 1 0",
+		"prod.dropped.errors;drop_reason=zero_value 0 1701424800",
+		"prod.carbon.batch 2 1701424800",
+	}, strings.Split(strings.TrimSuffix(f.metricDataToPlaintext(context.Background(), md), "\n"), "\n"))
+	assert.Equal(t, "prod.collector.shutdown 1 1701424800\n", f.shutdownMarker())
+}
+
+func TestToPlaintextEmitMovingAverage(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.EmitMovingAverage.Enabled = true
+	cfg.EmitMovingAverage.Window = 3
+	f := newTestFormatter(t, cfg)
+
+	newGauge := func(value float64) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+		gauge := ms.AppendEmpty()
+		gauge.SetName("cpu")
+		dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("host", "a")
+		dp.SetDoubleValue(value)
+		// Sums have no moving average.
+		sum := ms.AppendEmpty()
+		sum.SetName("requests")
+		sum.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(1)
+		return md
+	}
+
+	// The average includes the points of previous exports, up to the window,
+	// once they are written: the value of the failed export is only taken
+	// into account by its retry.
+	var got []string
+	for i, value := range []float64{1, 2, 3, 3, 4, 8} {
+		var buf bytes.Buffer
+		export := f.writePlaintext(context.Background(), &buf, newGauge(value))
+		if i == 2 {
+			f.forgetUnwritten(export)
+		} else {
+			f.recordWritten(export)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		require.Len(t, lines, 3)
+		got = append(got, lines[1])
+	}
+	assert.Equal(t, []string{
+		"cpu.avg;host=a 1 0",
+		"cpu.avg;host=a 1.5 0",
+		"cpu.avg;host=a 2 0",
+		"cpu.avg;host=a 2 0",
+		"cpu.avg;host=a 3 0",
+		"cpu.avg;host=a 5 0",
+	}, got)
+}
+
+func TestToPlaintextMetricsFormatDotted(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.MetricsFormat = metricsFormatDotted
+	f := newTestFormatter(t, cfg)
+
+	newMetrics := func(reverse bool) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+		gauge := ms.AppendEmpty()
+		gauge.SetName("test_0")
+		dp := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		attrs := [][2]string{{"k0", "v0"}, {"k1", "v1"}, {"host.name", "a b.c"}}
+		if reverse {
+			attrs = [][2]string{attrs[2], attrs[1], attrs[0]}
+		}
+		for _, kv := range attrs {
+			dp.Attributes().PutStr(kv[0], kv[1])
+		}
+		dp.SetIntValue(1)
+
+		histogram := ms.AppendEmpty()
+		histogram.SetName("latency")
+		hdp := histogram.SetEmptyHistogram().DataPoints().AppendEmpty()
+		hdp.Attributes().PutStr("k0", "v0")
+		hdp.ExplicitBounds().FromRaw([]float64{0.5})
+		hdp.BucketCounts().FromRaw([]uint64{1, 2})
+		hdp.SetCount(3)
+		hdp.SetSum(4)
+		return md
+	}
+
+	want := "test_0.host_name.a_b_c.k0.v0.k1.v1 1 0\n" +
+		"latency.count.k0.v0 3 0\n" +
+		"latency.k0.v0 4 0\n" +
+		"latency.bucket.k0.v0.upper_bound.0_5 1 0\n" +
+		"latency.bucket.k0.v0.upper_bound.inf 2 0\n"
+	// The path doesn't depend on the order of the attributes.
+	assert.Equal(t, want, f.metricDataToPlaintext(context.Background(), newMetrics(false)))
+	assert.Equal(t, want, f.metricDataToPlaintext(context.Background(), newMetrics(true)))
+}
+
+func TestToPlaintextSanitizeNames(t *testing.T) {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr(conventions.AttributeK8SPodName, "pod a;b=c")
+	m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("http requests")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("status code", "200 OK;ok")
+	dp.Attributes().PutStr("path", "/a\nb\tc")
+	dp.SetIntValue(1)
+
+	tests := []struct {
+		name        string
+		replacement string
+		want        string
+	}{
+		{
+			name:        "default",
+			replacement: "_",
+			want:        "http_requests;status_code=200_OK_ok;path=/a_b_c;pod=pod_a_b_c 1 0\n",
+		},
+		{
+			name:        "remove",
+			replacement: "",
+			want:        "httprequests;statuscode=200OKok;path=/abc;pod=podabc 1 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.KubernetesTags = true
+			cfg.SanitizeReplacement = tt.replacement
+			require.NoError(t, cfg.Validate())
+			got := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+			assert.Equal(t, tt.want, got)
+
+			// The line is still made of a path, a value and a timestamp, the
+			// path being the name followed by "key=value" tags.
+			require.Equal(t, 1, strings.Count(got, "\n"))
+			fields := strings.Fields(got)
+			require.Len(t, fields, 3)
+			tags := strings.Split(fields[0], ";")
+			assert.Len(t, tags, 4)
+			for _, tag := range tags[1:] {
+				assert.Len(t, strings.Split(tag, "="), 2, tag)
+			}
+		})
+	}
+}
+
+func TestToPlaintextNaNHandling(t *testing.T) {
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("gauge")
+	dps := gauge.SetEmptyGauge().DataPoints()
+	for i, v := range []float64{math.NaN(), math.Inf(1), 1.5} {
+		dp := dps.AppendEmpty()
+		dp.Attributes().PutInt("i", int64(i))
+		dp.SetDoubleValue(v)
+	}
+	sum := ms.AppendEmpty()
+	sum.SetName("sum")
+	sum.SetEmptySum().DataPoints().AppendEmpty().SetDoubleValue(math.Inf(-1))
+
+	tests := []struct {
+		nanHandling string
+		want        string
+	}{
+		{
+			nanHandling: nanHandlingSkip,
+			want:        "gauge;i=2 1.5 0\n",
+		},
+		{
+			nanHandling: nanHandlingZero,
+			want:        "gauge;i=0 0 0\ngauge;i=1 0 0\ngauge;i=2 1.5 0\nsum 0 0\n",
+		},
+		{
+			nanHandling: nanHandlingPassthrough,
+			want:        "gauge;i=0 NaN 0\ngauge;i=1 +Inf 0\ngauge;i=2 1.5 0\nsum -Inf 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nanHandling, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.NaNHandling = tt.nanHandling
+			assert.Equal(t, tt.want, newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md))
+		})
+	}
+}
+
+func TestToPlaintextSumConversion(t *testing.T) {
+	cumulative := func(seconds int64, value int64) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		sum := m.SetEmptySum()
+		sum.SetIsMonotonic(true)
+		sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		dp := sum.DataPoints().AppendEmpty()
+		dp.Attributes().PutStr("k", "v")
+		dp.SetStartTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, 0)))
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(time.Unix(seconds, 0)))
+		dp.SetIntValue(value)
+		return md
+	}
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: sumConversionNone, want: "requests;k=v;aggregationMethod=max 130 20\n"},
+		{mode: sumConversionDelta, want: "requests;k=v;aggregationMethod=sum 30 20\n"},
+		{mode: sumConversionRate, want: "requests;k=v;aggregationMethod=average 3 20\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.AggregationMethodTag = true
+			cfg.SumConversion.Mode = tt.mode
+			f := newTestFormatter(t, cfg)
+			export := func(md pmetric.Metrics) (string, exportState) {
+				var buf bytes.Buffer
+				state := f.writePlaintext(context.Background(), &buf, md)
+				return buf.String(), state
+			}
+
+			first, state := export(cumulative(10, 100))
+			f.recordWritten(state)
+			if tt.mode != sumConversionNone {
+				// There is nothing to compare the first point with.
+				assert.Empty(t, first)
+			}
+			// The failed export doesn't become the previous point of its
+			// retry.
+			got, state := export(cumulative(20, 130))
+			assert.Equal(t, tt.want, got)
+			f.forgetUnwritten(state)
+			got, state = export(cumulative(20, 130))
+			assert.Equal(t, tt.want, got)
+			f.recordWritten(state)
+		})
+	}
+}
+
+func TestToPlaintextDefaultTemporality(t *testing.T) {
+	md := pmetric.NewMetrics()
+	metric := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	metric.SetName("sum")
+	sum := metric.SetEmptySum()
+	sum.SetIsMonotonic(true)
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityUnspecified)
+	sum.DataPoints().AppendEmpty().SetIntValue(5)
+
+	tests := []struct {
+		defaultTemporality string
+		want               string
+	}{
+		{
+			defaultTemporality: temporalityCumulative,
+			want:               "sum;aggregationMethod=max 5 0\n",
+		},
+		{
+			defaultTemporality: temporalityDelta,
+			want:               "sum;aggregationMethod=sum 5 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.defaultTemporality, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.AggregationMethodTag = true
+			cfg.DefaultTemporality = tt.defaultTemporality
+			assert.Equal(t, tt.want, newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// movingAverages keeps the last window values of each gauge series, across the
+// exports written, to compute their moving average. A series is forgotten once
+// it wasn't updated for staleAfter. At most maxSeries series are remembered,
+// the least recently updated ones are forgotten first.
+type movingAverages struct {
+	mu         sync.Mutex
+	window     int
+	staleAfter time.Duration
+	maxSeries  int
+	// entries holds the *movingWindow values by increasing updatedAt.
+	entries *list.List
+	series  map[string]*list.Element
+}
+
+// movingWindow holds the last values of a series in a ring.
+type movingWindow struct {
+	key       string
+	values    []float64
+	next      int
+	updatedAt time.Time
+}
+
+func newMovingAverages(window int, staleAfter time.Duration, maxSeries int) *movingAverages {
+	return &movingAverages{
+		window:     window,
+		staleAfter: staleAfter,
+		maxSeries:  maxSeries,
+		entries:    list.New(),
+		series:     make(map[string]*list.Element),
+	}
+}
+
+// average returns the average of the last window values of the series
+// identified by key followed by the given values, of the export being
+// formatted, or of all of them if there are fewer. The values are only added
+// to the series by record.
+func (m *movingAverages) average(key string, values []float64, now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var all []float64
+	if e, ok := m.series[key]; ok {
+		if w := e.Value.(*movingWindow); now.Sub(w.updatedAt) < m.staleAfter {
+			all = append(all, w.values[w.next:]...)
+			all = append(all, w.values[:w.next]...)
+		}
+	}
+	all = append(all, values...)
+	if len(all) > m.window {
+		all = all[len(all)-m.window:]
+	}
+
+	sum := 0.0
+	for _, v := range all {
+		sum += v
+	}
+	return sum / float64(len(all))
+}
+
+// record adds the values of a written export, by series key, to their series
+// as of now.
+func (m *movingAverages) record(values map[string][]float64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for e := m.entries.Front(); e != nil && now.Sub(e.Value.(*movingWindow).updatedAt) >= m.staleAfter; e = m.entries.Front() {
+		m.remove(e)
+	}
+
+	for key, vs := range values {
+		var w *movingWindow
+		if e, ok := m.series[key]; ok {
+			w = e.Value.(*movingWindow)
+			m.entries.MoveToBack(e)
+		} else {
+			w = &movingWindow{key: key, values: make([]float64, 0, m.window)}
+			m.series[key] = m.entries.PushBack(w)
+			if m.entries.Len() > m.maxSeries {
+				m.remove(m.entries.Front())
+			}
+		}
+		w.updatedAt = now
+		for _, v := range vs {
+			if len(w.values) < m.window {
+				w.values = append(w.values, v)
+			} else {
+				w.values[w.next] = v
+				w.next = (w.next + 1) % m.window
+			}
+		}
+	}
+}
+
+func (m *movingAverages) remove(e *list.Element) {
+	delete(m.series, e.Value.(*movingWindow).key)
+	m.entries.Remove(e)
+}
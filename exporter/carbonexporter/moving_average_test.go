@@ -0,0 +1,34 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMovingAverages(t *testing.T) {
+	start := time.Unix(0, 0)
+	m := newMovingAverages(2, time.Minute, 2)
+
+	// The values of the export are averaged with the recorded ones, but
+	// only added to them by record.
+	assert.Equal(t, 2.0, m.average("a", []float64{1, 3}, start))
+	assert.Equal(t, 5.0, m.average("a", []float64{5}, start))
+	m.record(map[string][]float64{"a": {1, 3}}, start)
+	assert.Equal(t, 4.0, m.average("a", []float64{5}, start))
+
+	// Stale series are forgotten.
+	assert.Equal(t, 7.0, m.average("a", []float64{7}, start.Add(time.Minute)))
+
+	// The least recently updated series are forgotten beyond maxSeries.
+	now := start.Add(30 * time.Second)
+	m.record(map[string][]float64{"b": {1}}, now)
+	m.record(map[string][]float64{"c": {1}}, now)
+	assert.Equal(t, 3.0, m.average("a", []float64{3}, now))
+	assert.Equal(t, 2.0, m.average("b", []float64{3}, now))
+	assert.Len(t, m.series, 2)
+}
@@ -0,0 +1,168 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// pathTemplateData is the data available to Config.PathTemplate.
+type pathTemplateData struct {
+	// MetricName is the name of the metric as it is written without a
+	// template.
+	MetricName         string
+	ResourceAttributes map[string]any
+	Attributes         map[string]any
+	Scope              pathTemplateScope
+}
+
+type pathTemplateScope struct {
+	Name    string
+	Version string
+}
+
+func parsePathTemplate(text string) (*template.Template, error) {
+	return template.New("path_template").Parse(text)
+}
+
+// validatePathTemplate parses the template and renders it for a sample data
+// point, so that a template referring to missing fields fails at startup
+// rather than for every data point.
+func validatePathTemplate(text string) error {
+	tmpl, err := parsePathTemplate(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(io.Discard, &pathTemplateData{
+		MetricName:         "metric",
+		ResourceAttributes: map[string]any{},
+		Attributes:         map[string]any{},
+	})
+}
+
+// renderPath returns the name rendered by pathTemplate for a data point, the
+// metric name as it is when the template renders nothing. The name is
+// sanitized like the metric names, and its whitespaces, which separate the
+// fields and the lines, are replaced even without sanitizeNames. It returns
+// false when the template fails, in which case the metric name is used too.
+func (f *formatter) renderPath(data *pathTemplateData) (string, bool) {
+	var sb strings.Builder
+	if err := f.pathTemplate.Execute(&sb, data); err != nil {
+		return data.MetricName, false
+	}
+	if sb.Len() == 0 {
+		return data.MetricName, true
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return sanitizedRune
+		}
+		return r
+	}, f.sanitizeName(f.applyUnicodePolicy(sb.String()))), true
+}
+
+// splitByPath renders the name of each data point of the metric per
+// pathTemplate, counting the points whose template failed in the batch. It
+// returns the metric with its name when all its points share the same one,
+// otherwise a metric per name, in order of first appearance, holding the
+// points with that name.
+func (f *formatter) splitByPath(b *batch, metric pmetric.Metric, resource pcommon.Map, scope pcommon.InstrumentationScope) ([]pmetric.Metric, []string) {
+	data := &pathTemplateData{
+		MetricName:         f.metricName(metric),
+		ResourceAttributes: f.rawAttributes(resource),
+		Scope:              pathTemplateScope{Name: scope.Name(), Version: scope.Version()},
+	}
+	var names []string
+	points := make(map[string][]int)
+	for i, attrs := range dataPointAttributes(metric) {
+		data.Attributes = f.rawAttributes(attrs)
+		name, ok := f.renderPath(data)
+		if !ok {
+			b.pathTemplateFallbacks++
+		}
+		if _, seen := points[name]; !seen {
+			names = append(names, name)
+		}
+		points[name] = append(points[name], i)
+	}
+	if len(names) <= 1 {
+		if len(names) == 0 {
+			names = []string{data.MetricName}
+		}
+		return []pmetric.Metric{metric}, names
+	}
+
+	metrics := make([]pmetric.Metric, len(names))
+	for i, name := range names {
+		metrics[i] = copyDataPoints(metric, points[name])
+	}
+	return metrics, names
+}
+
+// dataPointAttributes returns the attributes of the data points of the metric.
+func dataPointAttributes(metric pmetric.Metric) []pcommon.Map {
+	var attributes []pcommon.Map
+	switch metric.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < metric.Gauge().DataPoints().Len(); i++ {
+			attributes = append(attributes, metric.Gauge().DataPoints().At(i).Attributes())
+		}
+	case pmetric.MetricTypeSum:
+		for i := 0; i < metric.Sum().DataPoints().Len(); i++ {
+			attributes = append(attributes, metric.Sum().DataPoints().At(i).Attributes())
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < metric.Histogram().DataPoints().Len(); i++ {
+			attributes = append(attributes, metric.Histogram().DataPoints().At(i).Attributes())
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < metric.Summary().DataPoints().Len(); i++ {
+			attributes = append(attributes, metric.Summary().DataPoints().At(i).Attributes())
+		}
+	}
+	return attributes
+}
+
+// copyDataPoints returns a metric like the given one holding a copy of its
+// data points at the given indexes only.
+func copyDataPoints(metric pmetric.Metric, indexes []int) pmetric.Metric {
+	m := pmetric.NewMetric()
+	m.SetName(metric.Name())
+	m.SetDescription(metric.Description())
+	m.SetUnit(metric.Unit())
+	switch metric.Type() {
+	case pmetric.MetricTypeGauge:
+		from, to := metric.Gauge().DataPoints(), m.SetEmptyGauge().DataPoints()
+		for _, i := range indexes {
+			from.At(i).CopyTo(to.AppendEmpty())
+		}
+	case pmetric.MetricTypeSum:
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(metric.Sum().AggregationTemporality())
+		sum.SetIsMonotonic(metric.Sum().IsMonotonic())
+		from, to := metric.Sum().DataPoints(), sum.DataPoints()
+		for _, i := range indexes {
+			from.At(i).CopyTo(to.AppendEmpty())
+		}
+	case pmetric.MetricTypeHistogram:
+		histogram := m.SetEmptyHistogram()
+		histogram.SetAggregationTemporality(metric.Histogram().AggregationTemporality())
+		from, to := metric.Histogram().DataPoints(), histogram.DataPoints()
+		for _, i := range indexes {
+			from.At(i).CopyTo(to.AppendEmpty())
+		}
+	case pmetric.MetricTypeSummary:
+		from, to := metric.Summary().DataPoints(), m.SetEmptySummary().DataPoints()
+		for _, i := range indexes {
+			from.At(i).CopyTo(to.AppendEmpty())
+		}
+	}
+	return m
+}
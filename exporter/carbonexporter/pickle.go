@@ -0,0 +1,139 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Pickle opcodes used to encode the frames, see Python's Lib/pickletools.py.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+
+	pickleProtocolVersion = 2
+	// pickleHeaderSize is the size of the big-endian length of the pickled
+	// payload prepended to it.
+	pickleHeaderSize = 4
+	// pickleFrameTrailerSize is the size of the opcodes ending a frame
+	// holding tuples, and pickleFrameOverhead the size of such a frame
+	// without its tuples.
+	pickleFrameTrailerSize = 2
+	pickleFrameOverhead    = pickleHeaderSize + 4 + pickleFrameTrailerSize
+)
+
+// appendPickleFrames appends to dst the frames of the Carbon pickle protocol
+// carrying the given plaintext lines, ie.: the length of the payload followed
+// by the payload, a pickled list of (path, (timestamp, value)) tuples. The
+// lines are all sent in a single frame unless maxFrameBytes is set, they are
+// then split across as many frames as needed for each to be at most
+// maxFrameBytes long, length included.
+func appendPickleFrames(dst *bytes.Buffer, plaintext []byte, maxFrameBytes int) error {
+	if len(plaintext) == 0 {
+		start := dst.Len()
+		dst.Write(make([]byte, pickleHeaderSize))
+		dst.Write([]byte{pickleProto, pickleProtocolVersion, pickleEmptyList, pickleStop})
+		binary.BigEndian.PutUint32(dst.Bytes()[start:], uint32(dst.Len()-start-pickleHeaderSize))
+		return nil
+	}
+
+	var tuple bytes.Buffer
+	// start is the offset in dst of the frame being built, -1 if none is.
+	start := -1
+	for len(plaintext) > 0 {
+		size := lineSize(plaintext)
+		line := string(bytes.TrimSuffix(plaintext[:size], []byte("\n")))
+		plaintext = plaintext[size:]
+
+		tuple.Reset()
+		if err := appendPickleTuple(&tuple, line); err != nil {
+			return err
+		}
+		if maxFrameBytes > 0 {
+			if pickleFrameOverhead+tuple.Len() > maxFrameBytes {
+				return fmt.Errorf("line %q doesn't fit in a pickle frame of %d bytes", line, maxFrameBytes)
+			}
+			if start >= 0 && dst.Len()-start+tuple.Len()+pickleFrameTrailerSize > maxFrameBytes {
+				closePickleFrame(dst, start)
+				start = -1
+			}
+		}
+		if start < 0 {
+			start = dst.Len()
+			dst.Write(make([]byte, pickleHeaderSize))
+			dst.Write([]byte{pickleProto, pickleProtocolVersion, pickleEmptyList, pickleMark})
+		}
+		dst.Write(tuple.Bytes())
+	}
+	closePickleFrame(dst, start)
+	return nil
+}
+
+// appendPickleTuple appends to dst the pickled (path, (timestamp, value))
+// tuple of a plaintext line.
+func appendPickleTuple(dst *bytes.Buffer, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid Carbon line %q", line)
+	}
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp in Carbon line %q: %w", line, err)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid value in Carbon line %q: %w", line, err)
+	}
+
+	appendPickleString(dst, fields[0])
+	appendPickleInt(dst, timestamp)
+	appendPickleFloat(dst, value)
+	dst.Write([]byte{pickleTuple2, pickleTuple2})
+	return nil
+}
+
+// closePickleFrame ends the frame starting at start in dst and sets its
+// length.
+func closePickleFrame(dst *bytes.Buffer, start int) {
+	dst.Write([]byte{pickleAppends, pickleStop})
+	binary.BigEndian.PutUint32(dst.Bytes()[start:], uint32(dst.Len()-start-pickleHeaderSize))
+}
+
+func appendPickleString(dst *bytes.Buffer, s string) {
+	dst.WriteByte(pickleBinUnicode)
+	_ = binary.Write(dst, binary.LittleEndian, uint32(len(s)))
+	dst.WriteString(s)
+}
+
+// appendPickleInt encodes i as a 4 bytes integer when it fits, as a long
+// otherwise.
+func appendPickleInt(dst *bytes.Buffer, i int64) {
+	if i >= math.MinInt32 && i <= math.MaxInt32 {
+		dst.WriteByte(pickleBinInt)
+		_ = binary.Write(dst, binary.LittleEndian, int32(i))
+		return
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(i))
+	dst.Write([]byte{pickleLong1, 8})
+	dst.Write(b[:])
+}
+
+func appendPickleFloat(dst *bytes.Buffer, f float64) {
+	dst.WriteByte(pickleBinFloat)
+	_ = binary.Write(dst, binary.BigEndian, math.Float64bits(f))
+}
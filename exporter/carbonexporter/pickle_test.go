@@ -0,0 +1,184 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pickleTuple is a decoded (path, (timestamp, value)) tuple.
+type pickleTuple struct {
+	path      string
+	timestamp int64
+	value     float64
+}
+
+// decodePickleFrame decodes a frame built by appendPickleFrames, it supports
+// only the opcodes used by the encoder.
+func decodePickleFrame(t *testing.T, frame []byte) []pickleTuple {
+	require.GreaterOrEqual(t, len(frame), pickleHeaderSize)
+	size := binary.BigEndian.Uint32(frame)
+	payload := frame[pickleHeaderSize:]
+	require.Len(t, payload, int(size))
+
+	var stack []any
+	var marks []int
+	pop := func() any {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	for i := 0; ; {
+		op := payload[i]
+		i++
+		switch op {
+		case pickleProto:
+			require.Equal(t, byte(pickleProtocolVersion), payload[i])
+			i++
+		case pickleEmptyList:
+			stack = append(stack, []pickleTuple{})
+		case pickleMark:
+			marks = append(marks, len(stack))
+		case pickleAppends:
+			mark := marks[len(marks)-1]
+			marks = marks[:len(marks)-1]
+			list := stack[mark-1].([]pickleTuple)
+			for _, v := range stack[mark:] {
+				list = append(list, v.(pickleTuple))
+			}
+			stack = append(stack[:mark-1], list)
+		case pickleBinUnicode:
+			n := int(binary.LittleEndian.Uint32(payload[i:]))
+			i += 4
+			stack = append(stack, string(payload[i:i+n]))
+			i += n
+		case pickleBinInt:
+			stack = append(stack, int64(int32(binary.LittleEndian.Uint32(payload[i:]))))
+			i += 4
+		case pickleLong1:
+			require.Equal(t, byte(8), payload[i])
+			stack = append(stack, int64(binary.LittleEndian.Uint64(payload[i+1:])))
+			i += 9
+		case pickleBinFloat:
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(payload[i:])))
+			i += 8
+		case pickleTuple2:
+			second, first := pop(), pop()
+			if path, ok := first.(string); ok {
+				point := second.([2]any)
+				stack = append(stack, pickleTuple{path: path, timestamp: point[0].(int64), value: point[1].(float64)})
+			} else {
+				stack = append(stack, [2]any{first, second})
+			}
+		case pickleStop:
+			require.Equal(t, len(payload), i, "data after the stop opcode")
+			require.Len(t, stack, 1)
+			return stack[0].([]pickleTuple)
+		default:
+			require.Fail(t, fmt.Sprintf("unexpected opcode %#x", op))
+		}
+	}
+}
+
+func TestAppendPickleFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		want      []pickleTuple
+		wantErr   bool
+	}{
+		{
+			name: "empty",
+			want: []pickleTuple{},
+		},
+		{
+			name:      "lines",
+			plaintext: "a.b;k0=v0 1 1700000000\nc -2.5 1700000001\n",
+			want: []pickleTuple{
+				{path: "a.b;k0=v0", timestamp: 1700000000, value: 1},
+				{path: "c", timestamp: 1700000001, value: -2.5},
+			},
+		},
+		{
+			name:      "large_timestamp",
+			plaintext: "a 1 4294967296\n",
+			want:      []pickleTuple{{path: "a", timestamp: 4294967296, value: 1}},
+		},
+		{
+			name:      "invalid_line",
+			plaintext: "a 1\n",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_value",
+			plaintext: "a b 1\n",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var frame bytes.Buffer
+			err := appendPickleFrames(&frame, []byte(tt.plaintext), 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, decodePickleFrame(t, frame.Bytes()))
+		})
+	}
+}
+
+func TestAppendPickleFramesMaxFrameBytes(t *testing.T) {
+	var plaintext strings.Builder
+	var want []pickleTuple
+	for i := 0; i < 10; i++ {
+		path := strings.Repeat("long.path.", 10) + strconv.Itoa(i)
+		plaintext.WriteString(path + " 1 1700000000\n")
+		want = append(want, pickleTuple{path: path, timestamp: 1700000000, value: 1})
+	}
+
+	const maxFrameBytes = 400
+	var frames bytes.Buffer
+	require.NoError(t, appendPickleFrames(&frames, []byte(plaintext.String()), maxFrameBytes))
+
+	var got []pickleTuple
+	data := frames.Bytes()
+	for len(data) > 0 {
+		size := pickleHeaderSize + int(binary.BigEndian.Uint32(data))
+		require.LessOrEqual(t, size, maxFrameBytes)
+		got = append(got, decodePickleFrame(t, data[:size])...)
+		data = data[size:]
+	}
+	assert.Equal(t, want, got)
+	assert.Greater(t, frames.Len(), maxFrameBytes, "the lines must span several frames")
+
+	// A line can't be split across frames.
+	assert.Error(t, appendPickleFrames(&frames, []byte(plaintext.String()), 64))
+}
+
+func TestAppendPickleFrameRoundTrip(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	md := generateMetricsBatch(5)
+	plaintext := newTestFormatter(t, cfg).metricDataToPlaintext(context.Background(), md)
+
+	var frame bytes.Buffer
+	require.NoError(t, appendPickleFrames(&frame, []byte(plaintext), 0))
+
+	var got bytes.Buffer
+	for _, tuple := range decodePickleFrame(t, frame.Bytes()) {
+		got.WriteString(buildLine(tuple.path, formatFloatForValue(tuple.value), formatInt64(tuple.timestamp)))
+	}
+	assert.Equal(t, plaintext, got.String())
+}
@@ -0,0 +1,47 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"sync"
+)
+
+// activeSeries collects the distinct paths of the lines generated since it
+// was last reset. At most maxSeries paths are collected, the count saturates
+// beyond.
+type activeSeries struct {
+	mu        sync.Mutex
+	maxSeries int
+	paths     map[string]struct{}
+}
+
+func newActiveSeries(maxSeries int) *activeSeries {
+	return &activeSeries{
+		maxSeries: maxSeries,
+		paths:     make(map[string]struct{}),
+	}
+}
+
+// add collects the paths of the lines of an export.
+func (as *activeSeries) add(paths []string) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for _, path := range paths {
+		if len(as.paths) >= as.maxSeries {
+			return
+		}
+		as.paths[path] = struct{}{}
+	}
+}
+
+// reset returns the number of distinct paths collected and forgets them.
+func (as *activeSeries) reset() int {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	n := len(as.paths)
+	as.paths = make(map[string]struct{})
+	return n
+}
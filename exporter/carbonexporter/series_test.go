@@ -0,0 +1,24 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveSeries(t *testing.T) {
+	as := newActiveSeries(3)
+
+	as.add([]string{"a", "b", "a"})
+	as.add([]string{"b"})
+	assert.Equal(t, 2, as.reset())
+	assert.Equal(t, 0, as.reset())
+
+	// The count saturates at maxSeries.
+	as.add([]string{"a", "b", "c", "d"})
+	as.add([]string{"e"})
+	assert.Equal(t, 3, as.reset())
+}
@@ -0,0 +1,45 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/exporter"
+)
+
+// startRetryExporter retries starting the wrapped exporter until it succeeds,
+// so a briefly unavailable storage extension backing the sending queue
+// doesn't fail the collector.
+type startRetryExporter struct {
+	exporter.Metrics
+	interval       time.Duration
+	maxElapsedTime time.Duration
+	clock          Clock
+}
+
+func (e *startRetryExporter) Start(ctx context.Context, host component.Host) error {
+	start := e.clock.Now()
+	ticker := e.clock.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		err := e.Metrics.Start(ctx, host)
+		if err == nil {
+			return nil
+		}
+		if e.maxElapsedTime > 0 && e.clock.Now().Sub(start) >= e.maxElapsedTime {
+			return fmt.Errorf("exporter failed to start within %s: %w", e.maxElapsedTime, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	scopeName = "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+	typeAttributeKey   = "type"
+	reasonAttributeKey = "reason"
+
+	// dropReasonTooOld is reported for data points older than MaxPointAge.
+	dropReasonTooOld = "too_old"
+	// dropReasonZeroValue is reported for zero valued data points of metrics
+	// matching SuppressZeros.
+	dropReasonZeroValue = "zero_value"
+	// dropReasonMaxLines is reported for the lines exceeding
+	// MaxLinesPerExport, each line being accounted as a data point.
+	dropReasonMaxLines = "max_lines"
+	// dropReasonShutdown is reported for the data discarded while shutting
+	// down per ShutdownDrainPolicy.
+	dropReasonShutdown = "shutdown"
+	// dropReasonMaintenance is reported for the data exported during one of
+	// the MaintenanceWindows.
+	dropReasonMaintenance = "maintenance"
+	// dropReasonDuplicate is reported for the lines already written by a
+	// previous export within the CrossExportDedupWindow, each line being
+	// accounted as a data point.
+	dropReasonDuplicate = "duplicate"
+	// dropReasonNonFinite is reported for the NaN and infinite values of
+	// gauges and sums dropped per NaNHandling.
+	dropReasonNonFinite = "non_finite"
+	// dropReasonBackpressure is reported for the data refused by the full
+	// sending queue.
+	dropReasonBackpressure = "backpressure"
+)
+
+// exporterTelemetry holds the instruments used by the exporter to report on
+// its own behavior.
+type exporterTelemetry struct {
+	pointsByType          metric.Int64Counter
+	droppedPoints         metric.Int64Counter
+	connectionErrors      metric.Int64Counter
+	bytesSent             metric.Int64Counter
+	linesSent             metric.Int64Counter
+	reconnects            metric.Int64Counter
+	pathTemplateFallbacks metric.Int64Counter
+}
+
+func newExporterTelemetry(set component.TelemetrySettings) (*exporterTelemetry, error) {
+	meter := set.MeterProvider.Meter(scopeName)
+
+	pointsByType, err := meter.Int64Counter(
+		"exporter_carbon_points_by_type",
+		metric.WithDescription("Number of data points received for serialization to Carbon, by metric type."),
+		metric.WithUnit("{datapoints}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	droppedPoints, err := meter.Int64Counter(
+		"exporter_carbon_dropped_points",
+		metric.WithDescription("Number of data points dropped by the exporter before being serialized, by reason."),
+		metric.WithUnit("{datapoints}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionErrors, err := meter.Int64Counter(
+		"exporter_carbon_connection_errors",
+		metric.WithDescription("Number of failures to establish or write to a TCP connection to Carbon."),
+		metric.WithUnit("{errors}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesSent, err := meter.Int64Counter(
+		"exporter_carbon_bytes_sent",
+		metric.WithDescription("Number of bytes written to Carbon."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	linesSent, err := meter.Int64Counter(
+		"exporter_carbon_lines_sent",
+		metric.WithDescription("Number of lines written to Carbon."),
+		metric.WithUnit("{lines}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reconnects, err := meter.Int64Counter(
+		"exporter_carbon_reconnects",
+		metric.WithDescription("Number of TCP connections to Carbon created to replace one that failed or was closed by the server."),
+		metric.WithUnit("{connections}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pathTemplateFallbacks, err := meter.Int64Counter(
+		"exporter_carbon_path_template_fallbacks",
+		metric.WithDescription("Number of data points named after their metric because the path template failed to render."),
+		metric.WithUnit("{datapoints}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &exporterTelemetry{
+		pointsByType:          pointsByType,
+		droppedPoints:         droppedPoints,
+		connectionErrors:      connectionErrors,
+		bytesSent:             bytesSent,
+		linesSent:             linesSent,
+		reconnects:            reconnects,
+		pathTemplateFallbacks: pathTemplateFallbacks,
+	}, nil
+}
+
+func (et *exporterTelemetry) recordPointsByType(ctx context.Context, pointsByType map[pmetric.MetricType]int) {
+	for metricType, points := range pointsByType {
+		et.pointsByType.Add(ctx, int64(points), metric.WithAttributes(
+			attribute.String(typeAttributeKey, strings.ToLower(metricType.String()))))
+	}
+}
+
+func (et *exporterTelemetry) recordDroppedPoints(ctx context.Context, droppedByReason map[string]int) {
+	for reason, points := range droppedByReason {
+		et.droppedPoints.Add(ctx, int64(points), metric.WithAttributes(
+			attribute.String(reasonAttributeKey, reason)))
+	}
+}
+
+func (et *exporterTelemetry) recordSent(ctx context.Context, bytes, lines int) {
+	et.bytesSent.Add(ctx, int64(bytes))
+	et.linesSent.Add(ctx, int64(lines))
+}
+
+func (et *exporterTelemetry) recordConnectionError(ctx context.Context) {
+	et.connectionErrors.Add(ctx, 1)
+}
+
+func (et *exporterTelemetry) recordReconnect(ctx context.Context) {
+	et.reconnects.Add(ctx, 1)
+}
+
+func (et *exporterTelemetry) recordPathTemplateFallbacks(ctx context.Context, points int) {
+	if points > 0 {
+		et.pathTemplateFallbacks.Add(ctx, int64(points))
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestPointsByTypeTelemetry(t *testing.T) {
+	set, reader := newTestTelemetrySettings()
+	telemetry, err := newExporterTelemetry(set)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("gauge")
+	gauge.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	gauge.Gauge().DataPoints().AppendEmpty().SetDoubleValue(2)
+	sum := ms.AppendEmpty()
+	sum.SetName("sum")
+	sum.SetEmptySum().DataPoints().AppendEmpty().SetIntValue(3)
+	histogram := ms.AppendEmpty()
+	histogram.SetName("histogram")
+	histogram.SetEmptyHistogram().DataPoints().AppendEmpty().SetCount(4)
+	summary := ms.AppendEmpty()
+	summary.SetName("summary")
+	summary.SetEmptySummary().DataPoints().AppendEmpty().SetCount(5)
+	summary.Summary().DataPoints().AppendEmpty().SetCount(6)
+	summary.Summary().DataPoints().AppendEmpty().SetCount(7)
+
+	f, err := newFormatter(createDefaultConfig().(*Config), telemetry, realClock{})
+	require.NoError(t, err)
+	f.metricDataToPlaintext(context.Background(), md)
+
+	assert.Equal(t, map[string]int64{
+		"gauge":     2,
+		"sum":       1,
+		"histogram": 1,
+		"summary":   3,
+	}, collectSums(t, reader, "exporter_carbon_points_by_type", typeAttributeKey))
+}
+
+func TestDroppedPointsTelemetry(t *testing.T) {
+	set, reader := newTestTelemetrySettings()
+	telemetry, err := newExporterTelemetry(set)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("gauge")
+	dps := m.SetEmptyGauge().DataPoints()
+	dps.AppendEmpty().SetTimestamp(pcommon.NewTimestampFromTime(time.Now()))
+	// Points without a timestamp are always older than the max age.
+	dps.AppendEmpty()
+	dps.AppendEmpty()
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MaxPointAge = time.Hour
+	f, err := newFormatter(cfg, telemetry, realClock{})
+	require.NoError(t, err)
+	f.metricDataToPlaintext(context.Background(), md)
+
+	assert.Equal(t, map[string]int64{
+		dropReasonTooOld: 2,
+	}, collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey))
+}
+
+func TestPathTemplateFallbacksTelemetry(t *testing.T) {
+	set, reader := newTestTelemetrySettings()
+	telemetry, err := newExporterTelemetry(set)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("requests")
+	dps := m.SetEmptyGauge().DataPoints()
+	for i, code := range []string{"200", "5", "404"} {
+		dp := dps.AppendEmpty()
+		dp.Attributes().PutStr("code", code)
+		dp.SetIntValue(int64(i))
+	}
+
+	cfg := createDefaultConfig().(*Config)
+	// Indexing the single character code is out of range.
+	cfg.PathTemplate = `{{.MetricName}}.{{if .Attributes.code}}{{printf "%c" (index .Attributes.code 1)}}{{end}}`
+	require.NoError(t, cfg.Validate())
+	f, err := newFormatter(cfg, telemetry, realClock{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "requests.0;code=200 0 0\nrequests.0;code=404 2 0\nrequests;code=5 1 0\n", f.metricDataToPlaintext(context.Background(), md))
+	assert.Equal(t, map[string]int64{
+		"": 1,
+	}, collectSums(t, reader, "exporter_carbon_path_template_fallbacks", reasonAttributeKey))
+}
+
+func TestMaxLinesPerExport(t *testing.T) {
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range []string{"c", "a", "d", "b", "e"} {
+		m := ms.AppendEmpty()
+		m.SetName(name)
+		m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	}
+
+	tests := []struct {
+		name               string
+		deterministicOrder bool
+		want               string
+	}{
+		{
+			name: "arrival_order",
+			want: "c 1 0\na 1 0\nd 1 0\n",
+		},
+		{
+			name:               "deterministic_order",
+			deterministicOrder: true,
+			want:               "a 1 0\nb 1 0\nc 1 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, reader := newTestTelemetrySettings()
+			telemetry, err := newExporterTelemetry(set)
+			require.NoError(t, err)
+			cfg := createDefaultConfig().(*Config)
+			cfg.MaxLinesPerExport = 3
+			cfg.DeterministicOrder = tt.deterministicOrder
+			f, err := newFormatter(cfg, telemetry, realClock{})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, f.metricDataToPlaintext(context.Background(), md))
+			assert.Equal(t, map[string]int64{
+				dropReasonMaxLines: 2,
+			}, collectSums(t, reader, "exporter_carbon_dropped_points", reasonAttributeKey))
+		})
+	}
+}
+
+func TestMaxLinesPerExportDedup(t *testing.T) {
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range []string{"c", "a", "d", "b", "e"} {
+		m := ms.AppendEmpty()
+		m.SetName(name)
+		m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(1)
+	}
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MaxLinesPerExport = 3
+	cfg.DeterministicOrder = true
+	cfg.CrossExportDedupWindow = time.Minute
+	cfg.SeriesCountInterval = time.Minute
+	f := newTestFormatter(t, cfg)
+	export := func() string {
+		var buf bytes.Buffer
+		f.recordWritten(f.writePlaintext(context.Background(), &buf, md))
+		return buf.String()
+	}
+
+	// The lines cut by the cap are neither remembered as written nor
+	// counted as series, so the next export writes them.
+	assert.Equal(t, "a 1 0\nb 1 0\nc 1 0\n", export())
+	assert.Equal(t, 3, f.activeSeries.reset())
+	assert.Equal(t, "d 1 0\ne 1 0\n", export())
+	assert.Equal(t, 2, f.activeSeries.reset())
+}
+
+// newNopTelemetry returns exporter telemetry discarding its measurements.
+func newNopTelemetry(t testing.TB) *exporterTelemetry {
+	telemetry, err := newExporterTelemetry(componenttest.NewNopTelemetrySettings())
+	require.NoError(t, err)
+	return telemetry
+}
+
+// newTestTelemetrySettings returns telemetry settings whose measurements can be
+// collected from the returned reader.
+func newTestTelemetrySettings() (component.TelemetrySettings, *sdkmetric.ManualReader) {
+	reader := sdkmetric.NewManualReader()
+	set := componenttest.NewNopTelemetrySettings()
+	set.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	return set, reader
+}
+
+// collectSums returns the values of the named counter grouped by the value of
+// the attribute attrKey, data points without the attribute are grouped under
+// the empty string.
+func collectSums(t *testing.T, reader *sdkmetric.ManualReader, name string, attrKey attribute.Key) map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			data, ok := m.Data.(metricdata.Sum[int64])
+			require.True(t, ok, "metric %q is not an int64 sum", name)
+			for _, dp := range data.DataPoints {
+				value, _ := dp.Attributes.Value(attrKey)
+				sums[value.AsString()] += dp.Value
+			}
+		}
+	}
+	return sums
+}
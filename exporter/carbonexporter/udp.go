@@ -0,0 +1,105 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package carbonexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/carbonexporter"
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+)
+
+// udpWriter sends the lines as UDP datagrams, one line per datagram or, when
+// mtu is set, as many whole lines as fit in mtu bytes. The socket is created
+// on the first write and kept afterwards, as there is no connection to
+// re-establish when a write fails.
+type udpWriter struct {
+	mtx      sync.Mutex
+	conn     *net.UDPConn
+	endpoint string
+	timeout  time.Duration
+	mtu      int
+}
+
+func newUDPWriter(cfg *Config) *udpWriter {
+	timeout := cfg.Timeout
+	if cfg.WriteTimeout > 0 {
+		timeout = cfg.WriteTimeout
+	}
+	return &udpWriter{
+		endpoint: cfg.Endpoint,
+		timeout:  timeout,
+		mtu:      cfg.MTU,
+	}
+}
+
+func (w *udpWriter) Write(_ context.Context, data []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.conn == nil {
+		addr, err := net.ResolveUDPAddr("udp", w.endpoint)
+		if err != nil {
+			if isInvalidAddressError(err) {
+				return 0, consumererror.NewPermanent(err)
+			}
+			return 0, classifyDNSError(err)
+		}
+		if w.conn, err = net.DialUDP("udp", nil, addr); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for len(data) > 0 {
+		size := nextDatagramSize(data, w.mtu)
+		n, err := w.conn.Write(data[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		data = data[size:]
+	}
+	return written, nil
+}
+
+func (w *udpWriter) Close() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// nextDatagramSize returns the size of the leading whole lines of data sent
+// in the next datagram. A line longer than mtu is sent alone.
+func nextDatagramSize(data []byte, mtu int) int {
+	size := lineSize(data)
+	for mtu > 0 && size < len(data) {
+		next := lineSize(data[size:])
+		if size+next > mtu {
+			break
+		}
+		size += next
+	}
+	return size
+}
+
+// lineSize returns the size of the first line of data, including the
+// new-line character.
+func lineSize(data []byte) int {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1
+	}
+	return len(data)
+}